@@ -66,6 +66,8 @@ func RecognizeAndDump(data []byte, withChecksums bool) (string, error) {
 		result, err = dumpXZ(data, withChecksums)
 	case bytes.HasPrefix(data, []byte{0x5d, 0x00, 0x00}):
 		result, err = dumpLZMA(data, withChecksums)
+	case bytes.HasPrefix(data, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		result, err = dumpZstd(data, withChecksums)
 	case len(data) >= 512 && bytes.Equal(data[257:262], []byte("ustar")):
 		result, err = DumpTar(data, withChecksums)
 	case bytes.HasPrefix(data, []byte("#mtree")):
@@ -127,6 +129,10 @@ func dumpLZMA(data []byte, withChecksums bool) (string, error) {
 	return dumpUsingProgram(data, withChecksums, "LZMA", "xz", "--format=lzma", "--decompress", "--stdout")
 }
 
+func dumpZstd(data []byte, withChecksums bool) (string, error) {
+	return dumpUsingProgram(data, withChecksums, "Zstandard", "zstd", "-d")
+}
+
 func dumpUsingProgram(data []byte, withChecksums bool, format string, command string, args ...string) (string, error) {
 	cmd := exec.Command(command, args...)
 	cmd.Stdin = bytes.NewReader(data)
@@ -0,0 +1,332 @@
+/*******************************************************************************
+*
+* Copyright 2018 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+//versionBound is one end of a versionRange. A nil *versionBound means that
+//the range is unbounded on that side.
+type versionBound struct {
+	Version   string
+	Inclusive bool
+}
+
+//versionRange is the set of versions matched by a list of VersionConstraints.
+type versionRange struct {
+	Lower *versionBound
+	Upper *versionBound
+}
+
+//intersect narrows the range down to the versions that also satisfy the
+//given constraint.
+func (r versionRange) intersect(c VersionConstraint, cmp VersionComparer) versionRange {
+	b := &versionBound{Version: c.Version, Inclusive: strings.Contains(c.Relation, "=")}
+	if c.Relation != "<" && c.Relation != "<=" {
+		if r.Lower == nil || isTighterLowerBound(b, r.Lower, cmp) {
+			r.Lower = b
+		}
+	}
+	if c.Relation != ">" && c.Relation != ">=" {
+		if r.Upper == nil || isTighterUpperBound(b, r.Upper, cmp) {
+			r.Upper = b
+		}
+	}
+	return r
+}
+
+//isTighterLowerBound returns true if the lower bound a excludes more versions
+//than the lower bound b, e.g. ">= 2.0" is tighter than ">= 1.0", and "> 1.0"
+//is tighter than ">= 1.0".
+func isTighterLowerBound(a, b *versionBound, cmp VersionComparer) bool {
+	result := cmp.CompareVersions(a.Version, b.Version)
+	return result > 0 || (result == 0 && !a.Inclusive && b.Inclusive)
+}
+
+//isTighterUpperBound is like isTighterLowerBound, but for upper bounds.
+func isTighterUpperBound(a, b *versionBound, cmp VersionComparer) bool {
+	result := cmp.CompareVersions(a.Version, b.Version)
+	return result < 0 || (result == 0 && !a.Inclusive && b.Inclusive)
+}
+
+//IsEmpty returns true if no version can satisfy this range.
+func (r versionRange) IsEmpty(cmp VersionComparer) bool {
+	if r.Lower == nil || r.Upper == nil {
+		return false
+	}
+	result := cmp.CompareVersions(r.Lower.Version, r.Upper.Version)
+	return result > 0 || (result == 0 && !(r.Lower.Inclusive && r.Upper.Inclusive))
+}
+
+//Contains returns true if the given version is inside this range.
+func (r versionRange) Contains(version string, cmp VersionComparer) bool {
+	if r.Lower != nil {
+		result := cmp.CompareVersions(version, r.Lower.Version)
+		if result < 0 || (result == 0 && !r.Lower.Inclusive) {
+			return false
+		}
+	}
+	if r.Upper != nil {
+		result := cmp.CompareVersions(version, r.Upper.Version)
+		if result > 0 || (result == 0 && !r.Upper.Inclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+//IsSubsetOf returns true if every version in this range is also in the other
+//range. This assumes that this range is not empty.
+func (r versionRange) IsSubsetOf(other versionRange, cmp VersionComparer) bool {
+	if other.Lower != nil {
+		if r.Lower == nil || isTighterLowerBound(other.Lower, r.Lower, cmp) {
+			return false
+		}
+	}
+	if other.Upper != nil {
+		if r.Upper == nil || isTighterUpperBound(other.Upper, r.Upper, cmp) {
+			return false
+		}
+	}
+	return true
+}
+
+func rangeOf(constraints []VersionConstraint, cmp VersionComparer) versionRange {
+	var r versionRange
+	for _, c := range constraints {
+		r = r.intersect(c, cmp)
+	}
+	return r
+}
+
+func formatConstraints(name string, constraints []VersionConstraint) string {
+	if len(constraints) == 0 {
+		return "\"" + name + "\""
+	}
+	strs := make([]string, len(constraints))
+	for idx, c := range constraints {
+		strs[idx] = fmt.Sprintf("\"%s %s %s\"", name, c.Relation, c.Version)
+	}
+	return strings.Join(strs, ", ")
+}
+
+//AnalyzeRelations checks the package's relations for mistakes that cannot
+//be found by looking at each relation on its own: requirements that cannot be
+//satisfied by any version, constraints that are implied by other constraints,
+//references of the package to itself, and conflicts that overlap with
+//requirements. The given VersionComparer (usually the Generator) defines how
+//versions are ordered.
+//
+//Note that multiple constraints on the same package in requires or
+//predepends must all be satisfied, whereas each constraint in conflicts,
+//breaks or replaces matches on its own (e.g. `conflicts = ["foo < 1",
+//"foo > 2"]` conflicts with both old and new versions of foo).
+//
+//Relations to package groups and alternatives are not analyzed. Errors
+//describe packages that can never be installed; warnings describe
+//constraints that are most likely not what the user intended.
+func (pkg *Package) AnalyzeRelations(cmp VersionComparer) (errs []error, warnings []string) {
+	ec := ErrorCollector{}
+
+	ownVersion := cmp.FullVersionString(pkg)
+
+	//requirements: all constraints must be satisfied at once
+	required := make(map[string]versionRange)
+	var requiredNames []string
+	for _, list := range []relationList{{"requires", pkg.Requires}, {"predepends", pkg.PreDepends}} {
+		for _, rel := range list.analyzableRelations() {
+			r := rangeOf(rel.Constraints, cmp)
+			if r.IsEmpty(cmp) {
+				ec.Addf("Version constraints %s cannot be satisfied by any version (found in %s)",
+					formatConstraints(rel.RelatedPackage, rel.Constraints), list.Type,
+				)
+				continue
+			}
+			warnings = append(warnings, findRedundantRequirements(rel, list.Type, cmp)...)
+
+			if rel.RelatedPackage == pkg.Name {
+				if r.Contains(ownVersion, cmp) {
+					warnings = append(warnings, fmt.Sprintf("Package %s requires itself; this is redundant (found in %s)", pkg.Name, describeRelation(rel, list.Type)))
+				} else {
+					ec.Addf("Package %s requires itself, but the version constraints do not match its own version %s (found in %s)", pkg.Name, ownVersion, describeRelation(rel, list.Type))
+				}
+			}
+
+			if prev, exists := required[rel.RelatedPackage]; exists {
+				r = intersectRanges(prev, r, cmp)
+			} else {
+				requiredNames = append(requiredNames, rel.RelatedPackage)
+			}
+			required[rel.RelatedPackage] = r
+		}
+	}
+
+	//provides: only check for self-references (the constraints are exact
+	//versions, so there is nothing to analyze)
+	for _, rel := range (relationList{"provides", pkg.Provides}).analyzableRelations() {
+		if rel.RelatedPackage == pkg.Name {
+			warnings = append(warnings, fmt.Sprintf("Package %s provides itself; this is redundant (found in provides)", pkg.Name))
+		}
+	}
+
+	//conflicts: each constraint matches on its own
+	conflicting := make(map[string][]versionRange)
+	for _, list := range []relationList{{"conflicts", pkg.Conflicts}, {"breaks", pkg.Breaks}, {"replaces", pkg.Replaces}} {
+		for _, rel := range list.analyzableRelations() {
+			ranges := make([]versionRange, 0, len(rel.Constraints))
+			for _, c := range rel.Constraints {
+				ranges = append(ranges, rangeOf([]VersionConstraint{c}, cmp))
+			}
+			if len(ranges) == 0 {
+				ranges = append(ranges, versionRange{}) //matches all versions
+			}
+			warnings = append(warnings, findRedundantConflicts(rel, ranges, list.Type, cmp)...)
+
+			if list.Type == "replaces" {
+				continue
+			}
+			if rel.RelatedPackage == pkg.Name {
+				for _, r := range ranges {
+					if r.Contains(ownVersion, cmp) {
+						ec.Addf("Package %s conflicts with its own version %s (found in %s)", pkg.Name, ownVersion, list.Type)
+						break
+					}
+				}
+			}
+			conflicting[rel.RelatedPackage] = append(conflicting[rel.RelatedPackage], ranges...)
+		}
+	}
+
+	//check for conflicts overlapping with requirements (self-references were
+	//already reported above)
+	for _, name := range requiredNames {
+		if name == pkg.Name {
+			continue
+		}
+		req := required[name]
+		overlaps := false
+		for _, conf := range conflicting[name] {
+			if req.IsSubsetOf(conf, cmp) {
+				ec.Addf("Package %s requires %s, but conflicts with all acceptable versions of it", pkg.Name, name)
+				overlaps = false
+				break
+			}
+			if !intersectRanges(req, conf, cmp).IsEmpty(cmp) {
+				overlaps = true
+			}
+		}
+		if overlaps {
+			warnings = append(warnings, fmt.Sprintf("Package %s requires %s, but conflicts with some acceptable versions of it; consider narrowing the version constraints in requires", pkg.Name, name))
+		}
+	}
+
+	return ec.Errors, warnings
+}
+
+type relationList struct {
+	Type string
+	Rels []PackageRelation
+}
+
+//analyzableRelations skips over relations that AnalyzeRelations() cannot
+//reason about, i.e. alternatives and package groups (including exclusions,
+//which are only meaningful together with a package group).
+func (l relationList) analyzableRelations() []PackageRelation {
+	var result []PackageRelation
+	for _, rel := range l.Rels {
+		if !rel.HasAlternatives() && rel.RelatedPackage == stripGroupSyntax(rel.RelatedPackage) {
+			result = append(result, rel)
+		}
+	}
+	return result
+}
+
+func intersectRanges(a, b versionRange, cmp VersionComparer) versionRange {
+	if b.Lower != nil {
+		c := VersionConstraint{Relation: ">", Version: b.Lower.Version}
+		if b.Lower.Inclusive {
+			c.Relation = ">="
+		}
+		a = a.intersect(c, cmp)
+	}
+	if b.Upper != nil {
+		c := VersionConstraint{Relation: "<", Version: b.Upper.Version}
+		if b.Upper.Inclusive {
+			c.Relation = "<="
+		}
+		a = a.intersect(c, cmp)
+	}
+	return a
+}
+
+//findRedundantRequirements returns a warning for each constraint of the
+//given requirement that is already implied by the other constraints. When the
+//same constraint is given twice, only the later one is reported.
+func findRedundantRequirements(rel PackageRelation, relType string, cmp VersionComparer) []string {
+	redundant := make([]bool, len(rel.Constraints))
+	for idx := len(rel.Constraints) - 1; idx >= 0; idx-- {
+		var others []VersionConstraint
+		for otherIdx, c := range rel.Constraints {
+			if otherIdx != idx && !redundant[otherIdx] {
+				others = append(others, c)
+			}
+		}
+		own := rangeOf(rel.Constraints[idx:idx+1], cmp)
+		if len(others) > 0 && rangeOf(others, cmp).IsSubsetOf(own, cmp) {
+			redundant[idx] = true
+		}
+	}
+	return redundancyWarnings(rel, redundant, relType)
+}
+
+//findRedundantConflicts returns a warning for each constraint of the given
+//conflict that only matches versions which are also matched by another
+//constraint. When the same constraint is given twice, only the later one is
+//reported.
+func findRedundantConflicts(rel PackageRelation, ranges []versionRange, relType string, cmp VersionComparer) []string {
+	if len(rel.Constraints) < 2 {
+		return nil
+	}
+	redundant := make([]bool, len(rel.Constraints))
+	for idx := len(ranges) - 1; idx >= 0; idx-- {
+		for otherIdx, other := range ranges {
+			if otherIdx != idx && !redundant[otherIdx] && ranges[idx].IsSubsetOf(other, cmp) {
+				redundant[idx] = true
+				break
+			}
+		}
+	}
+	return redundancyWarnings(rel, redundant, relType)
+}
+
+func redundancyWarnings(rel PackageRelation, redundant []bool, relType string) []string {
+	var warnings []string
+	for idx, c := range rel.Constraints {
+		if redundant[idx] {
+			warnings = append(warnings, fmt.Sprintf("Version constraint \"%s %s %s\" is redundant (found in %s)",
+				rel.RelatedPackage, c.Relation, c.Version, relType,
+			))
+		}
+	}
+	return warnings
+}
@@ -21,23 +21,28 @@
 package common
 
 import (
+	"fmt"
+	"io"
 	"sort"
 	"strings"
 )
 
-//Build builds the package using the given Generator.
-func (pkg *Package) Build(generator Generator) ([]byte, error) {
+//Build builds the package using the given Generator, and writes it into the
+//given writer.
+func (pkg *Package) Build(generator Generator, w io.Writer) error {
 	//do magical Holo integration tasks
 	pkg.doMagicalHoloIntegration()
 	//move unmaterializable filesystem metadata into the setupScript
 	pkg.postponeUnmaterializableFSMetadata()
 
 	//build package
-	return generator.Build(pkg)
+	return generator.Build(pkg, w)
 }
 
-func (pkg *Package) doMagicalHoloIntegration() {
-	//does this package need to provision stuff with Holo plugins?
+//HoloPluginIDs returns the IDs of all Holo plugins that this package
+//provisions files with (i.e. all plugins with files below
+//"/usr/share/holo/$plugin_id" in this package), in sorted order.
+func (pkg *Package) HoloPluginIDs() []string {
 	plugins := make(map[string]bool)
 	pkg.WalkFSWithAbsolutePaths(func(path string, node FSNode) error {
 		if strings.HasPrefix(path, "/usr/share/holo/") {
@@ -51,18 +56,24 @@ func (pkg *Package) doMagicalHoloIntegration() {
 		}
 		return nil
 	})
-	if len(plugins) == 0 {
-		return
-	}
 
-	//it does -> sort list of plugins for reproducibility...
+	//sort list of plugins for reproducibility
 	pluginIDs := make([]string, 0, len(plugins))
 	for pluginID := range plugins {
 		pluginIDs = append(pluginIDs, pluginID)
 	}
 	sort.Strings(pluginIDs)
+	return pluginIDs
+}
+
+func (pkg *Package) doMagicalHoloIntegration() {
+	//does this package need to provision stuff with Holo plugins?
+	pluginIDs := pkg.HoloPluginIDs()
+	if len(pluginIDs) == 0 {
+		return
+	}
 
-	//add all these Holo plugins to the list of requirements...
+	//it does -> add all these Holo plugins to the list of requirements...
 	for _, pluginID := range pluginIDs {
 		depName := "holo-" + pluginID
 		hasDep := false
@@ -78,10 +89,19 @@ func (pkg *Package) doMagicalHoloIntegration() {
 	}
 
 	//...and run `holo apply` during setup/cleanup
-	pkg.PrependActions(
+	pkg.PrependActions(pkg.holoIntegrationActions()...)
+}
+
+//holoIntegrationActions returns the actions that doMagicalHoloIntegration()
+//adds to the package.
+func (pkg *Package) holoIntegrationActions() []PackageAction {
+	if len(pkg.HoloPluginIDs()) == 0 {
+		return nil
+	}
+	return []PackageAction{
 		PackageAction{Type: SetupAction, Content: "holo apply"},
 		PackageAction{Type: CleanupAction, Content: "holo apply"},
-	)
+	}
 }
 
 func (pkg *Package) postponeUnmaterializableFSMetadata() {
@@ -90,21 +110,88 @@ func (pkg *Package) postponeUnmaterializableFSMetadata() {
 	//archive. Therefore, remove the Owner/Group from the FS entry and add a
 	//chown(1)/chgrp(1) call to the setupScript to apply ownership at install
 	//time.
+	pkg.PrependActions(pkg.unmaterializableFSMetadataActions()...)
 	pkg.WalkFSWithAbsolutePaths(func(path string, node FSNode) error {
-		var script string
 		switch n := node.(type) {
 		case *FSDirectory:
-			script = n.Metadata.PostponeUnmaterializable(path)
+			n.Metadata.PostponeUnmaterializable(path)
 		case *FSRegularFile:
-			script = n.Metadata.PostponeUnmaterializable(path)
+			n.Metadata.PostponeUnmaterializable(path)
+		case *FSGhostFile:
+			//ghost files are not present at install time, so their metadata
+			//can only be used by package formats that track it
 		default:
 			//don't do anything for FSNodes that don't have metadata
 		}
-		//ensure that ownership is correct before running the actual setup script
+		return nil
+	})
+}
+
+//unmaterializableFSMetadataActions returns the actions that
+//postponeUnmaterializableFSMetadata() adds to the package.
+func (pkg *Package) unmaterializableFSMetadataActions() []PackageAction {
+	var actions []PackageAction
+	pkg.WalkFSWithAbsolutePaths(func(path string, node FSNode) error {
+		var script string
+		switch n := node.(type) {
+		case *FSDirectory:
+			script = n.Metadata.UnmaterializableScript(path)
+		case *FSRegularFile:
+			script = n.Metadata.UnmaterializableScript(path)
+		}
+		//ensure that ownership is correct before running the actual setup
+		//script (each script goes in front of the previous ones)
 		if script != "" {
-			pkg.PrependActions(PackageAction{Type: SetupAction, Content: script})
+			actions = append([]PackageAction{PackageAction{Type: SetupAction, Content: script}}, actions...)
 		}
 		return nil
 	})
+	return actions
+}
 
+//WithBuildActions returns a copy of this package whose Actions include the
+//actions that Build() adds before calling the generator. Generators use this
+//to report their maintainer scripts before the package is built. The package
+//itself is not modified.
+func (pkg *Package) WithBuildActions() *Package {
+	p := *pkg
+	p.Actions = append([]PackageAction(nil), pkg.Actions...)
+	p.PrependActions(pkg.holoIntegrationActions()...)
+	p.PrependActions(pkg.unmaterializableFSMetadataActions()...)
+	return &p
+}
+
+//AlternativeActions returns the actions that register the package's
+//alternatives with update-alternatives(1) during setup, and unregister them
+//before the package is removed. This is used by the generators for package
+//formats whose package managers do not know about alternatives by themselves.
+func (pkg *Package) AlternativeActions() []PackageAction {
+	var actions []PackageAction
+	for _, alt := range pkg.Alternatives {
+		actions = append(actions,
+			PackageAction{
+				Type:    SetupAction,
+				Content: fmt.Sprintf("update-alternatives --install %s %s %s %d", alt.Link, alt.Name, alt.Path, alt.Priority),
+			},
+			PackageAction{
+				Type:    PreCleanupAction,
+				Content: fmt.Sprintf("update-alternatives --remove %s %s", alt.Name, alt.Path),
+			},
+		)
+	}
+	return actions
+}
+
+//GhostFileCleanupActions returns purge actions that delete all ghost files in
+//this package. This is used by the generators for package formats whose
+//package managers do not know about ghost files.
+func (pkg *Package) GhostFileCleanupActions() []PackageAction {
+	var actions []PackageAction
+	pkg.WalkFSWithAbsolutePaths(func(path string, node FSNode) error {
+		if _, ok := node.(*FSGhostFile); ok {
+			actions = append(actions, PackageAction{Type: PurgeAction, Content: "rm -f " + path})
+		}
+		return nil
+	})
+	return actions
 }
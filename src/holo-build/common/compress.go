@@ -0,0 +1,514 @@
+/*******************************************************************************
+*
+* Copyright 2018 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+//Compression identifies a compression format for package contents.
+type Compression string
+
+//Acceptable values for Compression. The empty value means that the generator
+//shall use the default compression of its package format.
+const (
+	CompressionDefault Compression = ""
+	CompressionNone    Compression = "none"
+	CompressionGzip    Compression = "gzip"
+	CompressionLZMA    Compression = "lzma"
+	CompressionXZ      Compression = "xz"
+	CompressionZstd    Compression = "zstd"
+)
+
+var compressionFileExtensions = map[Compression]string{
+	CompressionNone: "",
+	CompressionGzip: ".gz",
+	CompressionLZMA: ".lzma",
+	CompressionXZ:   ".xz",
+	CompressionZstd: ".zst",
+}
+
+//ParseCompression parses the value of the --compression option or the
+//package.compression field.
+func ParseCompression(str string) (Compression, error) {
+	c := Compression(str)
+	if _, ok := compressionFileExtensions[c]; !ok {
+		return CompressionDefault, fmt.Errorf("unknown compression \"%s\" (valid values: none, gzip, lzma, xz, zstd)", str)
+	}
+	return c, nil
+}
+
+//Or returns the given default compression if c is CompressionDefault, or c
+//itself otherwise.
+func (c Compression) Or(defaultCompression Compression) Compression {
+	if c == CompressionDefault {
+		return defaultCompression
+	}
+	return c
+}
+
+//FileExtension returns the file name extension for this compression format,
+//e.g. ".gz" for CompressionGzip, or "" for CompressionNone.
+func (c Compression) FileExtension() string {
+	return compressionFileExtensions[c]
+}
+
+//CompressionOptions contains settings for Compression.NewWriter() that can be
+//chosen on the command line.
+type CompressionOptions struct {
+	//Level is the compression level, or 0 for the default level of the
+	//compression format, or CompressionLevelFast for its fastest level.
+	Level int
+	//Jobs is the number of threads used for compression, or 0 to use one
+	//thread per CPU. The result does not depend on this value.
+	Jobs int
+}
+
+//CompressionLevelFast selects the fastest level of each compression format.
+const CompressionLevelFast = -1
+
+type compressionLevelRange struct {
+	Min     int
+	Max     int
+	Default int
+}
+
+//CompressionNone does not have levels. The default level for LZMA is 5 (which
+//uses the same dictionary size as 6) because RPM packages have always declared
+//this level in RPMTAG_PAYLOADFLAGS.
+var compressionLevelRanges = map[Compression]compressionLevelRange{
+	CompressionGzip: {1, 9, 6},
+	CompressionLZMA: {1, 9, 5},
+	CompressionXZ:   {1, 9, 6},
+	CompressionZstd: {1, 19, 3},
+}
+
+//ParseCompressionLevel parses the value of the --compression-level option.
+func ParseCompressionLevel(str string) (int, error) {
+	if str == "fast" {
+		return CompressionLevelFast, nil
+	}
+	level, err := strconv.Atoi(str)
+	if err != nil || level < 1 {
+		return 0, fmt.Errorf("invalid compression level \"%s\" (must be a positive number or \"fast\")", str)
+	}
+	return level, nil
+}
+
+//Level resolves the given compression level (as in CompressionOptions) into
+//the concrete level for this compression format.
+func (c Compression) Level(level int) int {
+	r := compressionLevelRanges[c]
+	switch level {
+	case 0:
+		return r.Default
+	case CompressionLevelFast:
+		return r.Min
+	default:
+		return level
+	}
+}
+
+//NewWriter returns a writer that compresses everything written into it with
+//this compression format, and writes the result into `w`. The result is only
+//complete after the writer has been closed. The `size` argument is the number
+//of bytes that will be written; it is only required for CompressionLZMA, and
+//may be -1 for all other compression formats.
+func (c Compression) NewWriter(w io.Writer, size int64, opts CompressionOptions) (io.WriteCloser, error) {
+	level := c.Level(opts.Level)
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	//Large inputs are split into chunks that are compressed independently
+	//(and in parallel). The chunk size only depends on the compression format
+	//and level, so the result is the same for any number of jobs.
+	switch c {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		//concatenated gzip members are a valid gzip file
+		return &chunkWriter{
+			Writer:    w,
+			ChunkSize: gzipChunkSize,
+			Jobs:      jobs,
+			Compress: func(chunk []byte) ([]byte, error) {
+				return compressGzip(chunk, level)
+			},
+		}, nil
+	case CompressionLZMA:
+		//the legacy format cannot be split into chunks
+		if size < 0 {
+			return nil, errors.New("lzma: uncompressed size must be known in advance")
+		}
+		return newLZMAWriter(w, size, level)
+	case CompressionXZ:
+		//each chunk becomes one block of the .xz stream
+		xw := &xzStreamWriter{Writer: w}
+		return &chunkWriter{
+			Writer:    xw,
+			ChunkSize: 3 * lzmaDictCaps[level], //like `xz --threads`
+			Jobs:      jobs,
+			Compress: func(chunk []byte) ([]byte, error) {
+				return compressXZ(chunk, level)
+			},
+			Finish: xw.Finish,
+		}, nil
+	case CompressionZstd:
+		//concatenated zstd frames are a valid zstd file
+		return &chunkWriter{
+			Writer:    w,
+			ChunkSize: zstdChunkSize,
+			Jobs:      jobs,
+			Compress: func(chunk []byte) ([]byte, error) {
+				return compressZstd(chunk, level)
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown compression \"%s\"", string(c))
+	}
+}
+
+//ValidateCompression checks that pkg.Compression is supported by a package
+//format. The allowed values must not include CompressionDefault.
+func (pkg *Package) ValidateCompression(formatName string, allowed ...Compression) []error {
+	if pkg.Compression == CompressionDefault {
+		return nil
+	}
+	names := make([]string, 0, len(allowed))
+	for _, c := range allowed {
+		if c == pkg.Compression {
+			return nil
+		}
+		names = append(names, string(c))
+	}
+	return []error{fmt.Errorf("compression \"%s\" is not supported for %s packages (valid values: %s)",
+		string(pkg.Compression), formatName, strings.Join(names, ", "))}
+}
+
+//ValidateCompressionLevel checks that pkg.CompressionOptions.Level is
+//supported by the given compression format.
+func (pkg *Package) ValidateCompressionLevel(c Compression) []error {
+	level := pkg.CompressionOptions.Level
+	r, ok := compressionLevelRanges[c]
+	if !ok || level <= 0 {
+		//default and fast levels are always fine
+		return nil
+	}
+	if level < r.Min || level > r.Max {
+		return []error{fmt.Errorf("compression level %d is not supported for %s compression (valid levels: %d-%d)",
+			level, string(c), r.Min, r.Max)}
+	}
+	return nil
+}
+
+//The compression settings are pinned here instead of relying on the defaults
+//of the compression libraries, so that the compressed output stays
+//byte-identical even if the libraries change their defaults. Since the
+//compressors are built into holo-build, the output does not depend on the
+//versions of compression programs installed on the build host.
+var (
+	lzmaProperties = lzma.Properties{LC: 3, LP: 0, PB: 2}
+	lzmaBufSize    = 4096
+	lzmaMatcher    = lzma.HashTable4
+	gzipChunkSize  = 4 << 20 //4 MiB
+	zstdWindowSize = 8 << 20 //8 MiB, like `zstd -3`
+	zstdChunkSize  = 4 * zstdWindowSize
+)
+
+//lzmaDictCaps contains the dictionary sizes for each compression level, like
+//in the presets of `xz`.
+var lzmaDictCaps = []int{
+	1: 1 << 20,
+	2: 2 << 20,
+	3: 4 << 20,
+	4: 4 << 20,
+	5: 8 << 20,
+	6: 8 << 20,
+	7: 16 << 20,
+	8: 32 << 20,
+	9: 64 << 20,
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+//chunkWriter is the io.WriteCloser returned by Compression.NewWriter() for
+//compression formats that compress chunks of a fixed size independently. It
+//collects up to `Jobs` chunks, compresses them in parallel, and writes the
+//compressed chunks into `Writer` in order, so that only a bounded amount of
+//data is held in memory at any time.
+type chunkWriter struct {
+	Writer    io.Writer
+	ChunkSize int
+	Jobs      int
+	Compress  func([]byte) ([]byte, error)
+	//Finish (if not nil) is called after all chunks have been written.
+	Finish func() error
+
+	chunks  [][]byte
+	current []byte
+	written bool
+}
+
+//Write implements the io.Writer interface.
+func (cw *chunkWriter) Write(data []byte) (int, error) {
+	total := len(data)
+	for len(data) > 0 {
+		n := cw.ChunkSize - len(cw.current)
+		if n > len(data) {
+			n = len(data)
+		}
+		cw.current = append(cw.current, data[:n]...)
+		data = data[n:]
+
+		if len(cw.current) == cw.ChunkSize {
+			cw.chunks = append(cw.chunks, cw.current)
+			cw.current = nil
+			if len(cw.chunks) == cw.Jobs {
+				err := cw.flush()
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	return total, nil
+}
+
+//Close implements the io.Closer interface. It does not close the underlying
+//writer.
+func (cw *chunkWriter) Close() error {
+	//empty input yields one empty chunk
+	if len(cw.current) > 0 || (!cw.written && len(cw.chunks) == 0) {
+		cw.chunks = append(cw.chunks, cw.current)
+		cw.current = nil
+	}
+	err := cw.flush()
+	if err != nil || cw.Finish == nil {
+		return err
+	}
+	return cw.Finish()
+}
+
+//flush compresses the collected chunks in parallel, and writes the results.
+func (cw *chunkWriter) flush() error {
+	results := make([][]byte, len(cw.chunks))
+	errs := make([]error, len(cw.chunks))
+
+	var wg sync.WaitGroup
+	for idx, chunk := range cw.chunks {
+		wg.Add(1)
+		go func(idx int, chunk []byte) {
+			defer wg.Done()
+			results[idx], errs[idx] = cw.Compress(chunk)
+		}(idx, chunk)
+	}
+	wg.Wait()
+	cw.chunks = cw.chunks[:0]
+
+	for idx, result := range results {
+		if errs[idx] != nil {
+			return errs[idx]
+		}
+		_, err := cw.Writer.Write(result)
+		if err != nil {
+			return err
+		}
+		cw.written = true
+	}
+	return nil
+}
+
+func compressGzip(data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return buf.Bytes(), err
+}
+
+//compressXZ compresses the given data into the .xz container format with a
+//single LZMA2 block and a CRC64 checksum.
+func compressXZ(data []byte, level int) ([]byte, error) {
+	props := lzmaProperties
+	cfg := xz.WriterConfig{
+		Properties: &props,
+		DictCap:    lzmaDictCaps[level],
+		BufSize:    lzmaBufSize,
+		CheckSum:   xz.CRC64,
+		Matcher:    lzmaMatcher,
+	}
+
+	var buf bytes.Buffer
+	w, err := cfg.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return buf.Bytes(), err
+}
+
+//xzStreamWriter combines several .xz streams with one block each (as
+//produced by compressXZ), which are written into it one at a time, into one
+//stream containing all blocks. The result is identical to what the xz library
+//produces when the same data is written into a single stream with the block
+//size set to the chunk size.
+type xzStreamWriter struct {
+	Writer  io.Writer
+	flags   []byte //from the stream header of the first stream
+	records []byte //index records describing the blocks written so far
+	count   int    //number of blocks written so far
+}
+
+const xzHeaderLen, xzFooterLen = 12, 12
+
+//Write implements the io.Writer interface. Each call must contain exactly one
+//complete .xz stream.
+func (xw *xzStreamWriter) Write(stream []byte) (int, error) {
+	if len(stream) < xzHeaderLen+xzFooterLen {
+		return 0, errors.New("xz: stream too short")
+	}
+	footer := stream[len(stream)-xzFooterLen:]
+	indexLen := 4 * (int(binary.LittleEndian.Uint32(footer[4:8])) + 1)
+	indexStart := len(stream) - xzFooterLen - indexLen
+	if indexStart < xzHeaderLen {
+		return 0, errors.New("xz: index size out of range")
+	}
+
+	//the index consists of the index indicator (0x00), the number of
+	//records (0x01), the record (two varints), padding and CRC32
+	index := stream[indexStart : len(stream)-xzFooterLen]
+	if index[0] != 0x00 || index[1] != 0x01 {
+		return 0, errors.New("xz: expected exactly one block per stream")
+	}
+	_, n1 := binary.Uvarint(index[2:])
+	_, n2 := binary.Uvarint(index[2+n1:])
+	if n1 <= 0 || n2 <= 0 {
+		return 0, errors.New("xz: malformed index record")
+	}
+	xw.records = append(xw.records, index[2:2+n1+n2]...)
+	xw.count++
+
+	//copy the stream header (only for the first stream) and the block
+	start := xzHeaderLen
+	if xw.flags == nil {
+		xw.flags = append([]byte(nil), stream[6:8]...)
+		start = 0
+	}
+	_, err := xw.Writer.Write(stream[start:indexStart])
+	return len(stream), err
+}
+
+//Finish writes the index and the stream footer.
+func (xw *xzStreamWriter) Finish() error {
+	//write the combined index
+	index := []byte{0x00}
+	index = append(index, appendUvarint(nil, uint64(xw.count))...)
+	index = append(index, xw.records...)
+	for len(index)%4 != 0 {
+		index = append(index, 0x00)
+	}
+	index = appendUint32LE(index, crc32.ChecksumIEEE(index))
+
+	//write the stream footer (CRC32, backward size, stream flags, magic)
+	footer := appendUint32LE(nil, uint32(len(index)/4-1))
+	footer = append(footer, xw.flags...)
+	buf := append(index, appendUint32LE(nil, crc32.ChecksumIEEE(footer))...)
+	buf = append(buf, footer...)
+	buf = append(buf, "YZ"...)
+	_, err := xw.Writer.Write(buf)
+	return err
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], x)
+	return append(buf, tmp[:n]...)
+}
+
+func appendUint32LE(buf []byte, x uint32) []byte {
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], x)
+	return append(buf, tmp[:]...)
+}
+
+//newLZMAWriter returns a writer that compresses into the legacy .lzma format
+//(also known as "LZMA-alone"). The uncompressed size is recorded in the
+//header, so no end-of-stream marker is written.
+func newLZMAWriter(w io.Writer, size int64, level int) (io.WriteCloser, error) {
+	props := lzmaProperties
+	cfg := lzma.WriterConfig{
+		Properties:   &props,
+		DictCap:      lzmaDictCaps[level],
+		BufSize:      lzmaBufSize,
+		Matcher:      lzmaMatcher,
+		SizeInHeader: true,
+		Size:         size,
+	}
+	return cfg.NewWriter(w)
+}
+
+//compressZstd compresses the given data into a single zstd frame with a
+//checksum.
+func compressZstd(data []byte, level int) ([]byte, error) {
+	w, err := zstd.NewWriter(nil,
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+		zstd.WithWindowSize(zstdWindowSize),
+		zstd.WithEncoderConcurrency(1), //EncodeAll() does not need more
+		zstd.WithEncoderCRC(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	return w.EncodeAll(data, nil), nil
+}
@@ -0,0 +1,282 @@
+/*******************************************************************************
+*
+* Copyright 2018 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package common
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+//elfArchitecture describes how ELF binaries for an architecture can be
+//recognized.
+type elfArchitecture struct {
+	Name         string //for error messages
+	Machine      elf.Machine
+	Class        elf.Class
+	ByteOrder    elf.Data       //or ELFDATANONE if irrelevant
+	FlagsMask    uint32         //if not 0, only match when e_flags & FlagsMask != 0
+	Architecture []Architecture //all architectures that can run these binaries
+}
+
+const (
+	//from the ARM ELF ABI (not defined in debug/elf)
+	elfARMFloatSoft = 0x200
+	elfARMFloatHard = 0x400
+)
+
+//Entries are checked from top to bottom, so more specific entries need to come
+//first. The ARM variants cannot be told apart from the ELF header alone
+//(except for the floating-point ABI), so they yield multiple candidates.
+var elfArchitectures = []elfArchitecture{
+	{"i386", elf.EM_386, elf.ELFCLASS32, elf.ELFDATANONE, 0, []Architecture{ArchitectureI386}},
+	{"x86_64", elf.EM_X86_64, elf.ELFCLASS64, elf.ELFDATANONE, 0, []Architecture{ArchitectureX86_64}},
+	{"ARM (soft-float)", elf.EM_ARM, elf.ELFCLASS32, elf.ELFDATA2LSB, elfARMFloatSoft, []Architecture{ArchitectureARMv5}},
+	{"ARM (hard-float)", elf.EM_ARM, elf.ELFCLASS32, elf.ELFDATA2LSB, elfARMFloatHard, []Architecture{ArchitectureARMv6h, ArchitectureARMv7h}},
+	{"ARM", elf.EM_ARM, elf.ELFCLASS32, elf.ELFDATA2LSB, 0, []Architecture{ArchitectureARMv5, ArchitectureARMv6h, ArchitectureARMv7h}},
+	{"aarch64", elf.EM_AARCH64, elf.ELFCLASS64, elf.ELFDATA2LSB, 0, []Architecture{ArchitectureAArch64}},
+	{"ppc64le", elf.EM_PPC64, elf.ELFCLASS64, elf.ELFDATA2LSB, 0, []Architecture{ArchitecturePPC64LE}},
+	{"s390x", elf.EM_S390, elf.ELFCLASS64, elf.ELFDATANONE, 0, []Architecture{ArchitectureS390X}},
+	{"riscv64", elf.EM_RISCV, elf.ELFCLASS64, elf.ELFDATA2LSB, 0, []Architecture{ArchitectureRISCV64}},
+	{"mips64el", elf.EM_MIPS, elf.ELFCLASS64, elf.ELFDATA2LSB, 0, []Architecture{ArchitectureMIPS64EL}},
+	{"loong64", elf.EM_LOONGARCH, elf.ELFCLASS64, elf.ELFDATA2LSB, 0, []Architecture{ArchitectureLoong64}},
+}
+
+//elfFirmwarePaths contains the directories where ELF binaries for other
+//processors (e.g. firmware for peripheral devices) may be shipped in any
+//package.
+var elfFirmwarePaths = []string{"/lib/firmware/", "/usr/lib/firmware/"}
+
+//elfBinary is an ELF file found in the package.
+type elfBinary struct {
+	Path    string
+	Machine elf.Machine
+	Class   elf.Class
+	Arch    *elfArchitecture //nil if unknown
+	SoNames []string         //from DT_SONAME
+	Needed  []string         //from DT_NEEDED
+}
+
+//Description returns a description of the binary's architecture for use in
+//error messages.
+func (b elfBinary) Description() string {
+	if b.Arch != nil {
+		return b.Arch.Name
+	}
+	return fmt.Sprintf("unknown machine type %s", b.Machine)
+}
+
+//findELFBinaries returns all regular files in the package that are ELF
+//objects, except for those in elfFirmwarePaths.
+func (pkg *Package) findELFBinaries() []elfBinary {
+	var result []elfBinary
+	pkg.WalkFSWithAbsolutePaths(func(path string, node FSNode) error {
+		file, ok := node.(*FSRegularFile)
+		if !ok {
+			return nil
+		}
+		for _, prefix := range elfFirmwarePaths {
+			if strings.HasPrefix(path, prefix) {
+				return nil
+			}
+		}
+		binary, ok := readELFBinary(path, file)
+		if ok {
+			result = append(result, binary)
+		}
+		return nil
+	})
+	return result
+}
+
+//readELFBinary returns ok = false if the given file is not an ELF object (or
+//one that we cannot read anyway).
+func readELFBinary(path string, file *FSRegularFile) (binary elfBinary, ok bool) {
+	head, err := file.ReadHead(len(elf.ELFMAG))
+	if err != nil || string(head) != elf.ELFMAG {
+		return elfBinary{}, false
+	}
+	r, err := file.Open()
+	if err != nil {
+		return elfBinary{}, false
+	}
+	defer r.Close()
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return elfBinary{}, false
+	}
+
+	binary = elfBinary{
+		Path:    path,
+		Machine: f.Machine,
+		Class:   f.Class,
+		Arch:    identifyELF(f, r),
+	}
+	binary.SoNames, _ = f.DynString(elf.DT_SONAME)
+	binary.Needed, _ = f.DynString(elf.DT_NEEDED)
+	return binary, true
+}
+
+//identifyELF returns the elfArchitecture for the given ELF file, or nil if the
+//architecture is not known to holo-build.
+func identifyELF(f *elf.File, r io.ReaderAt) *elfArchitecture {
+	for idx, arch := range elfArchitectures {
+		if f.Machine != arch.Machine || f.Class != arch.Class {
+			continue
+		}
+		if arch.ByteOrder != elf.ELFDATANONE && f.Data != arch.ByteOrder {
+			continue
+		}
+		if arch.FlagsMask != 0 && elfFlags(f, r)&arch.FlagsMask == 0 {
+			continue
+		}
+		return &elfArchitectures[idx]
+	}
+	return nil
+}
+
+//elfFlags reads the e_flags field of the ELF header, which is not exposed by
+//debug/elf.
+func elfFlags(f *elf.File, r io.ReaderAt) uint32 {
+	offset := int64(36) //in Elf32_Ehdr
+	if f.Class == elf.ELFCLASS64 {
+		offset = 48 //in Elf64_Ehdr
+	}
+	buf := make([]byte, 4)
+	if _, err := r.ReadAt(buf, offset); err != nil {
+		return 0
+	}
+	return f.ByteOrder.Uint32(buf)
+}
+
+//checkELFBinaries reports an error for each ELF binary in the package that
+//cannot run on the package's architecture. If deriveArchitecture is true,
+//pkg.Architecture is set from the ELF binaries first (or to ArchitectureAny
+//if there are none).
+func (pkg *Package) checkELFBinaries(deriveArchitecture bool, ec *ErrorCollector) {
+	binaries := pkg.findELFBinaries()
+
+	if deriveArchitecture {
+		arch, ok := deriveArchitectureFromELF(binaries, ec)
+		if !ok {
+			return
+		}
+		pkg.Architecture = arch
+		pkg.ArchitectureInput = architectureNames[arch]
+	}
+
+	//raw architectures are the user's responsibility
+	if pkg.Architecture == ArchitectureRaw {
+		return
+	}
+	archInput := pkg.ArchitectureInput
+	if archInput == "" {
+		archInput = "any"
+	}
+
+	for _, binary := range binaries {
+		if pkg.Architecture == ArchitectureAny {
+			ec.Addf("file \"%s\" is invalid: ELF binary for %s cannot be shipped in a package with architecture \"%s\"",
+				binary.Path, binary.Description(), archInput,
+			)
+			continue
+		}
+		if binary.Arch == nil || !containsArchitecture(binary.Arch.Architecture, pkg.Architecture) {
+			ec.Addf("file \"%s\" is invalid: ELF binary for %s does not match package architecture \"%s\"",
+				binary.Path, binary.Description(), archInput,
+			)
+		}
+	}
+}
+
+//deriveArchitectureFromELF implements `architecture = "auto"`.
+func deriveArchitectureFromELF(binaries []elfBinary, ec *ErrorCollector) (Architecture, bool) {
+	if len(binaries) == 0 {
+		return ArchitectureAny, true
+	}
+
+	//find the architectures that can run all binaries
+	candidates := []Architecture(nil)
+	for idx, binary := range binaries {
+		if binary.Arch == nil {
+			ec.Addf("cannot derive package architecture: file \"%s\" is an ELF binary for %s", binary.Path, binary.Description())
+			return ArchitectureAny, false
+		}
+		if idx == 0 {
+			candidates = binary.Arch.Architecture
+			continue
+		}
+		var remaining []Architecture
+		for _, arch := range candidates {
+			if containsArchitecture(binary.Arch.Architecture, arch) {
+				remaining = append(remaining, arch)
+			}
+		}
+		if len(remaining) == 0 {
+			ec.Addf("cannot derive package architecture: ELF binaries for %s (\"%s\") and %s (\"%s\") cannot be shipped in the same package",
+				binaries[0].Description(), binaries[0].Path, binary.Description(), binary.Path,
+			)
+			return ArchitectureAny, false
+		}
+		candidates = remaining
+	}
+
+	if len(candidates) > 1 {
+		names := make([]string, len(candidates))
+		for idx, arch := range candidates {
+			names[idx] = "\"" + architectureNames[arch] + "\""
+		}
+		sort.Strings(names)
+		ec.Addf("cannot derive package architecture: ELF binaries for %s could be for any of %s; please choose one explicitly",
+			binaries[0].Description(), strings.Join(names, ", "),
+		)
+		return ArchitectureAny, false
+	}
+	return candidates[0], true
+}
+
+func containsArchitecture(list []Architecture, arch Architecture) bool {
+	for _, a := range list {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+//architectureNames contains the preferred input string for each
+//architecture, as used in messages and for `architecture = "auto"`.
+var architectureNames = map[Architecture]string{
+	ArchitectureAny:      "any",
+	ArchitectureI386:     "i686",
+	ArchitectureX86_64:   "x86_64",
+	ArchitectureARMv5:    "armv5tl",
+	ArchitectureARMv6h:   "armv6h",
+	ArchitectureARMv7h:   "armv7h",
+	ArchitectureAArch64:  "aarch64",
+	ArchitecturePPC64LE:  "ppc64le",
+	ArchitectureS390X:    "s390x",
+	ArchitectureRISCV64:  "riscv64",
+	ArchitectureMIPS64EL: "mips64el",
+	ArchitectureLoong64:  "loong64",
+}
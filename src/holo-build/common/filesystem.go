@@ -22,12 +22,16 @@ package common
 
 import (
 	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"sort"
+	"strings"
 )
 
 //FSNode instances represent an entry in the file system (such as a file or a
@@ -94,17 +98,28 @@ func (m *FSNodeMetadata) GID() uint32 {
 //PostponeUnmaterializable generates an addition to the package's setup script
 //to handle metadata at install-time that cannot be materialized at build-time
 //(namely owners/groups identified by name which cannot be resolved into
-//numeric IDs at build time).
+//numeric IDs at build time), and removes that metadata from this node.
 func (m *FSNodeMetadata) PostponeUnmaterializable(path string) (additionalSetupScript string) {
-	var ownerStr, groupStr string
+	script := m.UnmaterializableScript(path)
 	if m.Owner != nil && m.Owner.Str != "" {
-		ownerStr = m.Owner.Str
 		m.Owner = nil
 	}
 	if m.Group != nil && m.Group.Str != "" {
-		groupStr = m.Group.Str
 		m.Group = nil
 	}
+	return script
+}
+
+//UnmaterializableScript returns the addition to the package's setup script
+//that PostponeUnmaterializable() generates, without modifying this node.
+func (m *FSNodeMetadata) UnmaterializableScript(path string) string {
+	var ownerStr, groupStr string
+	if m.Owner != nil {
+		ownerStr = m.Owner.Str
+	}
+	if m.Group != nil {
+		groupStr = m.Group.Str
+	}
 
 	if ownerStr != "" {
 		if groupStr != "" {
@@ -237,9 +252,67 @@ func (d *FSDirectory) Walk(absolutePath string, callback func(string, FSNode) er
 //
 
 //FSRegularFile is a type of FSNode that represents regular files.
+//
+//The contents are either given in memory (in the Content field), or read from
+//a file on disk whenever they are needed (see NewFSRegularFileFromPath), so
+//that large files do not need to be held in memory while the package is built.
 type FSRegularFile struct {
 	Content  string
 	Metadata FSNodeMetadata
+	//the following fields are only set by NewFSRegularFileFromPath()
+	sourcePath string
+	source     *fileDigests
+}
+
+//fileDigests contains the size and checksums of a file on disk.
+type fileDigests struct {
+	Size   int64
+	MD5    string
+	SHA1   string
+	SHA256 string
+}
+
+//NewFSRegularFileFromPath creates a FSRegularFile whose contents are read from
+//the given file on disk whenever they are needed. The file is read once right
+//away to compute its size and checksums.
+//
+//Files that cannot be read twice (e.g. pipes or character devices) are read
+//into memory instead.
+func NewFSRegularFileFromPath(path string) (*FSRegularFile, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	fi, err := r.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return &FSRegularFile{Content: string(content)}, nil
+	}
+	f := &FSRegularFile{sourcePath: path}
+
+	md5sum := md5.New()
+	sha1sum := sha1.New()
+	sha256sum := sha256.New()
+	size, err := io.Copy(io.MultiWriter(md5sum, sha1sum, sha256sum), r)
+	if err != nil {
+		return nil, err
+	}
+
+	f.source = &fileDigests{
+		Size:   size,
+		MD5:    hex.EncodeToString(md5sum.Sum(nil)),
+		SHA1:   hex.EncodeToString(sha1sum.Sum(nil)),
+		SHA256: hex.EncodeToString(sha256sum.Sum(nil)),
+	}
+	return f, nil
 }
 
 //Insert implements the FSNode interface.
@@ -247,12 +320,12 @@ func (f *FSRegularFile) Insert(entry FSNode, relPath []string, location string)
 	if len(relPath) == 0 {
 		return errors.New("duplicate entry")
 	}
-	return fmt.Errorf("%f is not a directory", location)
+	return fmt.Errorf("%s is not a directory", location)
 }
 
 //InstalledSizeInBytes implements the FSNode interface.
 func (f *FSRegularFile) InstalledSizeInBytes() int {
-	return len(f.Content)
+	return int(f.Size())
 }
 
 //FileModeForArchive implements the FSNode interface.
@@ -268,8 +341,92 @@ func (f *FSRegularFile) Walk(absolutePath string, callback func(string, FSNode)
 	return callback(absolutePath, f)
 }
 
+//FileReader is returned by FSRegularFile.Open().
+type FileReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+}
+
+//stringFileReader is the FileReader for FSRegularFile instances whose
+//contents are held in memory.
+type stringFileReader struct {
+	*strings.Reader
+}
+
+//Close implements the io.Closer interface.
+func (stringFileReader) Close() error {
+	return nil
+}
+
+//Open returns a reader for this file's contents. The caller must close it.
+func (f *FSRegularFile) Open() (FileReader, error) {
+	if f.sourcePath == "" {
+		return stringFileReader{strings.NewReader(f.Content)}, nil
+	}
+	return os.Open(f.sourcePath)
+}
+
+//WriteContentsTo copies this file's contents into the given writer.
+func (f *FSRegularFile) WriteContentsTo(w io.Writer) error {
+	if f.sourcePath == "" {
+		_, err := io.WriteString(w, f.Content)
+		return err
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	//the size and digests of the file have already been written into the
+	//archive headers (and into SBOMs etc.), so its contents must not have
+	//changed since NewFSRegularFileFromPath()
+	sha256sum := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, sha256sum), io.LimitReader(r, f.source.Size+1))
+	if err == nil && (n != f.source.Size || hex.EncodeToString(sha256sum.Sum(nil)) != f.source.SHA256) {
+		err = fmt.Errorf("%s has changed while the package was being built", f.sourcePath)
+	}
+	return err
+}
+
+//ReadHead returns up to the given number of bytes from the start of this
+//file's contents.
+func (f *FSRegularFile) ReadHead(length int) ([]byte, error) {
+	if f.sourcePath == "" {
+		if len(f.Content) < length {
+			length = len(f.Content)
+		}
+		return []byte(f.Content[:length]), nil
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	buf := make([]byte, length)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buf[:n], err
+}
+
+//Size returns the size of this file's contents in bytes.
+func (f *FSRegularFile) Size() int64 {
+	if f.source != nil {
+		return f.source.Size
+	}
+	return int64(len(f.Content))
+}
+
 //MD5Digest returns the MD5 digest of this file's contents.
 func (f *FSRegularFile) MD5Digest() string {
+	if f.source != nil {
+		return f.source.MD5
+	}
 	//the following is equivalent to sum := md5.Sum([]byte(f.Content)),
 	//but also is backwards-compatible to Go 1.1
 	digest := md5.New()
@@ -279,8 +436,20 @@ func (f *FSRegularFile) MD5Digest() string {
 	return hex.EncodeToString(sum[:])
 }
 
+//SHA1Digest returns the SHA1 digest of this file's contents.
+func (f *FSRegularFile) SHA1Digest() string {
+	if f.source != nil {
+		return f.source.SHA1
+	}
+	sum := sha1.Sum([]byte(f.Content))
+	return hex.EncodeToString(sum[:])
+}
+
 //SHA256Digest returns the SHA256 digest of this file's contents.
 func (f *FSRegularFile) SHA256Digest() string {
+	if f.source != nil {
+		return f.source.SHA256
+	}
 	//the following is equivalent to sum := sha256.Sum([]byte(f.Content)),
 	//but also is backwards-compatible to Go 1.1
 	digest := sha256.New()
@@ -304,7 +473,7 @@ func (s *FSSymlink) Insert(entry FSNode, relPath []string, location string) erro
 	if len(relPath) == 0 {
 		return errors.New("duplicate entry")
 	}
-	return fmt.Errorf("%f is not a directory", location)
+	return fmt.Errorf("%s is not a directory", location)
 }
 
 //InstalledSizeInBytes implements the FSNode interface.
@@ -324,3 +493,41 @@ func (s *FSSymlink) FileModeForArchive(includingFileType bool) uint32 {
 func (s *FSSymlink) Walk(absolutePath string, callback func(string, FSNode) error) error {
 	return callback(absolutePath, s)
 }
+
+////////////////////////////////////////////////////////////////////////////////
+// FSGhostFile
+//
+
+//FSGhostFile is a type of FSNode that represents a file that is owned by the
+//package, but not shipped in it because it is created at runtime (e.g. log
+//files, generated caches or sockets). Generators must not put these into the
+//package archive.
+type FSGhostFile struct {
+	Metadata FSNodeMetadata
+}
+
+//Insert implements the FSNode interface.
+func (g *FSGhostFile) Insert(entry FSNode, relPath []string, location string) error {
+	if len(relPath) == 0 {
+		return errors.New("duplicate entry")
+	}
+	return fmt.Errorf("%s is not a directory", location)
+}
+
+//InstalledSizeInBytes implements the FSNode interface.
+func (g *FSGhostFile) InstalledSizeInBytes() int {
+	return 0
+}
+
+//FileModeForArchive implements the FSNode interface.
+func (g *FSGhostFile) FileModeForArchive(includingFileType bool) uint32 {
+	if includingFileType {
+		return 0100000 | (uint32(g.Metadata.Mode) & 07777)
+	}
+	return uint32(g.Metadata.Mode) & 07777
+}
+
+//Walk implements the FSNode interface.
+func (g *FSGhostFile) Walk(absolutePath string, callback func(string, FSNode) error) error {
+	return callback(absolutePath, g)
+}
@@ -20,6 +20,8 @@
 
 package common
 
+import "io"
+
 //Generator is a generic interface for the package generator implementations.
 //One Generator exists for every target package format (e.g. pacman, dpkg, RPM)
 //supported by holo-build.
@@ -31,13 +33,46 @@ type Generator interface {
 	//
 	//If the package is valid, an empty slice is to be returned.
 	Validate(pkg *Package) []error
-	//Build produces the final package (usually a compressed tar file) in the
-	//return argument. The package must be built reproducibly; such that every
-	//run (even across systems) produces an identical result. For example, no
-	//timestamps or generator version information may be included.
-	Build(pkg *Package) ([]byte, error)
+	//Build produces the final package (usually a compressed tar file) and
+	//writes it into the given writer. The package must be built reproducibly;
+	//such that every run (even across systems) produces an identical result.
+	//For example, no timestamps or generator version information may be
+	//included. File contents should be streamed instead of being held in
+	//memory, since packages can be much larger than the available memory.
+	Build(pkg *Package, w io.Writer) error
 	//Generate the recommended file name for this package. Distributions
 	//usually have guidelines for this sort of thing. The string returned must
 	//be a plain file name, not a path.
 	RecommendedFileName(pkg *Package) string
+	//ArchitectureName returns the name of the package's architecture as it
+	//appears in the package's metadata and in RecommendedFileName().
+	ArchitectureName(pkg *Package) string
+	//MaintainerScripts returns the maintainer scripts that Build() will put
+	//into the package, indexed by name. Each script starts with a shebang line
+	//for the interpreter that the package manager runs it with (even if the
+	//package format records the interpreter elsewhere). This is called before
+	//Build() and must not modify pkg.
+	MaintainerScripts(pkg *Package) map[string]string
+}
+
+//VersionComparer is implemented by Generators whose package managers have a
+//well-defined ordering of versions. (All Generators in holo-build implement
+//it, but it is kept separate from Generator since most callers do not need it.)
+type VersionComparer interface {
+	//CompareVersions compares two version strings (which may include epoch
+	//and release) in the same way as the package manager, and returns -1 if
+	//a < b, 0 if a == b, or 1 if a > b.
+	CompareVersions(a, b string) int
+	//FullVersionString returns the version of the package (including epoch
+	//and release) as it appears in the package's metadata and in
+	//RecommendedFileName().
+	FullVersionString(pkg *Package) string
+}
+
+//MetadataFileLister is implemented by Generators that place metadata files
+//among the package's files during Build() (e.g. .PKGINFO for pacman).
+type MetadataFileLister interface {
+	//MetadataFiles returns the paths of these metadata files, relative to the
+	//package's root directory.
+	MetadataFiles() []string
 }
@@ -0,0 +1,329 @@
+/*******************************************************************************
+*
+* Copyright 2018 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package common
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+//GroupResolver resolves package groups (as referenced by "group:foo" in
+//package relations) into the names of their member packages.
+type GroupResolver interface {
+	ResolvePackageGroup(groupName string) ([]string, error)
+}
+
+//NewGroupResolver creates a GroupResolver that reads package groups from the
+//given source, which may be a pacman sync database (e.g. "core.db"), a
+//directory containing multiple sync databases, or a TOML file mapping group
+//names to lists of package names. If the source is empty, package groups are
+//resolved by asking pacman(8).
+func NewGroupResolver(source string) (GroupResolver, error) {
+	if source == "" {
+		return pacmanGroupResolver{}, nil
+	}
+
+	fi, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(staticGroupResolver)
+	switch {
+	case fi.IsDir():
+		paths, err := filepath.Glob(filepath.Join(source, "*.db"))
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("no sync databases (*.db) found in %s", source)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			err := groups.readSyncDatabase(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case strings.HasSuffix(source, ".toml"):
+		var data map[string][]string
+		_, err := toml.DecodeFile(source, &data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s: %s", source, err.Error())
+		}
+		for groupName, pkgNames := range data {
+			for _, pkgName := range pkgNames {
+				groups.add(groupName, pkgName)
+			}
+		}
+	default:
+		err := groups.readSyncDatabase(source)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return groups, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// pacmanGroupResolver
+//
+
+//pacmanGroupResolver asks pacman(8) to resolve package groups.
+type pacmanGroupResolver struct{}
+
+//ResolvePackageGroup implements the GroupResolver interface.
+func (pacmanGroupResolver) ResolvePackageGroup(groupName string) ([]string, error) {
+	//mock implementation (for unit tests): read package names from group name
+	//(e.g. "group:foo-bar-baz" contains packages "foo", "bar", "baz")
+	if value := os.Getenv("HOLO_MOCK"); value == "1" {
+		return strings.Split(groupName, "-"), nil
+	}
+
+	//actual implementation: call pacman to resolve package groups
+	cmd := exec.Command("pacman", "-Sqg", groupName)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve package group \"%s\" with pacman (use --package-groups to read package groups from a file instead): %s", groupName, err.Error())
+	}
+
+	return strings.Fields(string(out)), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// staticGroupResolver
+//
+
+//staticGroupResolver resolves package groups from a map that was read from a
+//file (group name -> sorted list of package names).
+type staticGroupResolver map[string][]string
+
+//ResolvePackageGroup implements the GroupResolver interface.
+func (groups staticGroupResolver) ResolvePackageGroup(groupName string) ([]string, error) {
+	pkgNames, exists := groups[groupName]
+	if !exists {
+		return nil, fmt.Errorf("unknown package group \"%s\"", groupName)
+	}
+	return pkgNames, nil
+}
+
+func (groups staticGroupResolver) add(groupName, pkgName string) {
+	pkgNames := groups[groupName]
+	idx := sort.SearchStrings(pkgNames, pkgName)
+	if idx < len(pkgNames) && pkgNames[idx] == pkgName {
+		return //already known
+	}
+	pkgNames = append(pkgNames, "")
+	copy(pkgNames[idx+1:], pkgNames[idx:])
+	pkgNames[idx] = pkgName
+	groups[groupName] = pkgNames
+}
+
+//readSyncDatabase reads group memberships from a pacman sync database, which
+//is a (usually compressed) tar archive containing one "$pkgname-$pkgver/desc"
+//file per package.
+func (groups staticGroupResolver) readSyncDatabase(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	data, err = decompressSyncDatabase(data)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %s", path, err.Error())
+	}
+
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read %s: %s", path, err.Error())
+		}
+		if filepath.Base(hdr.Name) != "desc" {
+			continue
+		}
+
+		fields := parseSyncDatabaseDesc(tr)
+		for _, pkgName := range fields["NAME"] {
+			for _, groupName := range fields["GROUPS"] {
+				groups.add(groupName, pkgName)
+			}
+		}
+	}
+}
+
+//parseSyncDatabaseDesc parses a "desc" file from a pacman sync database. These
+//consist of sections like "%NAME%\nfoo\n\n%GROUPS%\nbar\nbaz\n\n".
+func parseSyncDatabaseDesc(r io.Reader) map[string][]string {
+	fields := make(map[string][]string)
+	var currentField string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			currentField = ""
+		case currentField == "" && strings.HasPrefix(line, "%") && strings.HasSuffix(line, "%"):
+			currentField = strings.Trim(line, "%")
+		case currentField != "":
+			fields[currentField] = append(fields[currentField], line)
+		}
+	}
+	return fields
+}
+
+//decompressSyncDatabase detects the compression format of a pacman sync
+//database and decompresses it (uncompressed data is returned as-is).
+func decompressSyncDatabase(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1F, 0x8B}):
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	case bytes.HasPrefix(data, []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}):
+		r, err := xz.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	case bytes.HasPrefix(data, []byte{0x28, 0xB5, 0x2F, 0xFD}):
+		r, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	default:
+		return data, nil
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// resolution of package relations
+//
+
+//ResolvePackageGroups expands references to package groups ("group:foo") in
+//the package's relations into their member packages, and removes packages
+//from the result that are excluded with "except:foo" or "except:group:foo".
+func (pkg *Package) ResolvePackageGroups(resolver GroupResolver) error {
+	var err error
+	lists := []*[]PackageRelation{
+		&pkg.Requires, &pkg.PreDepends, &pkg.Provides,
+		&pkg.Conflicts, &pkg.Breaks, &pkg.Replaces,
+	}
+	for _, rels := range lists {
+		*rels, err = resolvePackageGroupsIn(*rels, resolver)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resolvePackageGroupsIn(rels []PackageRelation, resolver GroupResolver) ([]PackageRelation, error) {
+	//acceptPkg marks which packages will be included in the result
+	//(e.g. "except:foo" sets acceptPkg["foo"] = false)
+	acceptPkg := make(map[string]bool, len(rels))
+
+	//read all input relations, and filter plain package relations (those that
+	//are not groups or negations)
+	actualRels := make([]PackageRelation, 0, len(rels))
+	for _, rel := range rels {
+		//alternatives cannot contain groups (this is checked by ValidateWith)
+		if rel.HasAlternatives() {
+			actualRels = append(actualRels, rel)
+			continue
+		}
+
+		name := rel.RelatedPackage
+		isNegated := strings.HasPrefix(name, "except:")
+		name = strings.TrimPrefix(name, "except:")
+		isGroup := strings.HasPrefix(name, "group:")
+		name = strings.TrimPrefix(name, "group:")
+
+		if isGroup {
+			//resolve groups
+			pkgs, err := resolver.ResolvePackageGroup(name)
+			if err != nil {
+				return nil, err
+			}
+
+			//accept packages in this group if not negated
+			for _, pkgName := range pkgs {
+				acceptPkg[pkgName] = !isNegated
+			}
+		} else {
+			acceptPkg[name] = !isNegated
+			if !isNegated {
+				actualRels = append(actualRels, rel)
+			}
+		}
+	}
+
+	//prune all not-accepted packages from actualRels (but keep multiple
+	//relations to the same package, e.g. "foo >= 1.0" and "foo < 2.0")
+	prunedRels := make([]PackageRelation, 0, len(actualRels))
+	isPresent := make(map[string]bool, len(actualRels))
+	for _, rel := range actualRels {
+		if rel.HasAlternatives() || acceptPkg[rel.RelatedPackage] {
+			prunedRels = append(prunedRels, rel)
+		}
+		isPresent[rel.RelatedPackage] = true
+	}
+
+	//add all missing relations (these are all accepted packages that came
+	//from groups)
+	additionalRels := make([]PackageRelation, 0, len(acceptPkg))
+	for pkgName, accepted := range acceptPkg {
+		if accepted && !isPresent[pkgName] {
+			additionalRels = append(additionalRels, PackageRelation{RelatedPackage: pkgName})
+		}
+	}
+	sort.Sort(byRelatedPackage(additionalRels))
+	return append(prunedRels, additionalRels...), nil
+}
+
+//implement sort.Sort interface for package relations
+type byRelatedPackage []PackageRelation
+
+func (b byRelatedPackage) Len() int           { return len(b) }
+func (b byRelatedPackage) Less(i, j int) bool { return b[i].RelatedPackage < b[j].RelatedPackage }
+func (b byRelatedPackage) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
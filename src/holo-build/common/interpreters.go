@@ -0,0 +1,137 @@
+/*******************************************************************************
+*
+* Copyright 2018 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package common
+
+import (
+	"sort"
+	"strings"
+)
+
+//interpreter is an interpreter that is used by a script in the package.
+type interpreter struct {
+	Path   string
+	UsedBy string //the first script using it (for error messages)
+}
+
+//shebangMaxLength is how much of a script is read to find its shebang line.
+//Linux does not look further than this, either.
+const shebangMaxLength = 256
+
+//parseShebang returns the absolute path of the interpreter named in the
+//shebang line of the given script, or "" if there is none. For
+//"#!/usr/bin/env foo", the interpreter is assumed to be "/usr/bin/foo".
+func parseShebang(script string) string {
+	if !strings.HasPrefix(script, "#!") {
+		return ""
+	}
+	line := strings.SplitN(script[2:], "\n", 2)[0]
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return ""
+	}
+	if fields[0] != "/usr/bin/env" && fields[0] != "/bin/env" {
+		return fields[0]
+	}
+	//skip options and variable assignments for env(1)
+	for _, field := range fields[1:] {
+		if strings.HasPrefix(field, "-") || strings.Contains(field, "=") {
+			continue
+		}
+		if strings.Contains(field, "/") {
+			return "" //relative path, cannot be resolved
+		}
+		return "/usr/bin/" + field
+	}
+	return ""
+}
+
+//findInterpreters returns the interpreters used by executable scripts in
+//this package, and by the maintainer scripts that the given generator writes.
+//Interpreters that are contained in the package itself are not included.
+func (pkg *Package) findInterpreters(generator Generator) []interpreter {
+	var result []interpreter
+	seen := make(map[string]bool)
+	add := func(path, usedBy string) {
+		if path != "" && !seen[path] {
+			seen[path] = true
+			result = append(result, interpreter{path, usedBy})
+		}
+	}
+
+	scripts := generator.MaintainerScripts(pkg)
+	scriptNames := make([]string, 0, len(scripts))
+	for name := range scripts {
+		scriptNames = append(scriptNames, name)
+	}
+	sort.Strings(scriptNames)
+	for _, name := range scriptNames {
+		add(parseShebang(scripts[name]), "maintainer script "+name)
+	}
+
+	contained := make(map[string]bool)
+	pkg.WalkFSWithAbsolutePaths(func(path string, node FSNode) error {
+		contained[path] = true
+		if file, ok := node.(*FSRegularFile); ok && file.Metadata.Mode&0111 != 0 {
+			head, _ := file.ReadHead(shebangMaxLength)
+			add(parseShebang(string(head)), path)
+		}
+		return nil
+	})
+
+	filtered := result[:0]
+	for _, interp := range result {
+		if !contained[interp.Path] {
+			filtered = append(filtered, interp)
+		}
+	}
+	return filtered
+}
+
+//AddInterpreterRelations adds requirements on the interpreters used by the
+//scripts in this package, i.e. by executable files with a shebang line, and
+//by the maintainer scripts that the given generator writes. This does nothing
+//unless the package definition contains `autoInterpreters = true`.
+//
+//For RPM, the requirements refer to the interpreter's path directly (e.g.
+//"/usr/bin/python3"). For other package formats, the requirements are looked
+//up in the package's InterpreterMap, and interpreters without an entry are
+//reported as errors.
+func (pkg *Package) AddInterpreterRelations(generator Generator, formatName string) []error {
+	if !pkg.AutoInterpreters {
+		return nil
+	}
+	ec := &ErrorCollector{}
+
+	for _, interp := range pkg.findInterpreters(generator) {
+		name := interp.Path
+		if formatName != "rpm" {
+			var exists bool
+			name, exists = pkg.InterpreterMap[interp.Path][formatName]
+			if !exists {
+				ec.Addf("Interpreter \"%s\" has no entry for %s in the interpreter map (used by %s)", interp.Path, formatName, interp.UsedBy)
+				continue
+			}
+		}
+		pkg.Requires = appendGeneratedRelation(pkg.Requires, name, "used by "+interp.UsedBy)
+	}
+
+	return ec.Errors
+}
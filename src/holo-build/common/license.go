@@ -0,0 +1,271 @@
+/*******************************************************************************
+*
+* Copyright 2018 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package common
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+//licenseExpression is a parsed SPDX license expression (see SPDX
+//specification, annex D). Leaves contain a License (or exception)
+//identifier, all other nodes contain an Operator ("AND", "OR" or "WITH").
+type licenseExpression struct {
+	Operator string
+	License  string
+	Operands []*licenseExpression
+}
+
+var (
+	licenseTokenRx = regexp.MustCompile(`\(|\)|[^\s()]+`)
+	licenseIDRx    = regexp.MustCompile(`^(?:DocumentRef-[a-zA-Z0-9.-]+:)?[a-zA-Z0-9.-]+\+?$`)
+)
+
+//parseLicenseExpression parses and validates an SPDX license expression like
+//"MIT OR Apache-2.0". Only the syntax is checked, so any identifier that looks
+//like an SPDX license identifier is accepted.
+func parseLicenseExpression(input string) (*licenseExpression, error) {
+	p := &licenseParser{tokens: licenseTokenRx.FindAllString(input, -1)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("expression is empty")
+	}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected \"%s\"", p.tokens[p.pos])
+	}
+	return expr, nil
+}
+
+//licenseParser is a recursive-descent parser for SPDX license expressions.
+type licenseParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *licenseParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *licenseParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+//parseOr parses `and-expression ("OR" and-expression)*`.
+func (p *licenseParser) parseOr() (*licenseExpression, error) {
+	return p.parseChain("OR", p.parseAnd)
+}
+
+//parseAnd parses `with-expression ("AND" with-expression)*`.
+func (p *licenseParser) parseAnd() (*licenseExpression, error) {
+	return p.parseChain("AND", p.parseWith)
+}
+
+func (p *licenseParser) parseChain(operator string, parseOperand func() (*licenseExpression, error)) (*licenseExpression, error) {
+	expr, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != operator {
+		return expr, nil
+	}
+	expr = &licenseExpression{Operator: operator, Operands: []*licenseExpression{expr}}
+	for p.peek() == operator {
+		p.next()
+		operand, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		expr.Operands = append(expr.Operands, operand)
+	}
+	return expr, nil
+}
+
+//parseWith parses `simple-expression ["WITH" exception-id]`, where
+//simple-expression is either a license identifier or a parenthesized
+//expression.
+func (p *licenseParser) parseWith() (*licenseExpression, error) {
+	var expr *licenseExpression
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case token == "(":
+		var err error
+		expr, err = p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing != ")" {
+			if closing == "" {
+				return nil, fmt.Errorf("missing \")\"")
+			}
+			return nil, fmt.Errorf("unexpected \"%s\"", closing)
+		}
+		return expr, nil //"WITH" may only follow a license identifier
+	case isLicenseOperator(token) || !licenseIDRx.MatchString(token):
+		return nil, fmt.Errorf("unexpected \"%s\"", token)
+	default:
+		expr = &licenseExpression{License: token}
+	}
+
+	if p.peek() != "WITH" {
+		return expr, nil
+	}
+	p.next()
+	exception := p.next()
+	if exception == "" || isLicenseOperator(exception) || !licenseIDRx.MatchString(exception) || strings.HasSuffix(exception, "+") {
+		return nil, fmt.Errorf("expected license exception after \"WITH\"")
+	}
+	return &licenseExpression{
+		Operator: "WITH",
+		Operands: []*licenseExpression{expr, {License: exception}},
+	}, nil
+}
+
+func isLicenseOperator(token string) bool {
+	switch strings.ToUpper(token) {
+	case "AND", "OR", "WITH", "(", ")":
+		return true
+	}
+	return false
+}
+
+//format renders the expression in canonical form, with the given spelling
+//for each operator. Parentheses are only inserted where necessary.
+func (e *licenseExpression) format(operators map[string]string) string {
+	if e.Operator == "" {
+		return e.License
+	}
+	terms := make([]string, 0, len(e.Operands))
+	for _, operand := range e.Operands {
+		term := operand.format(operators)
+		//"OR" binds weaker than "AND" (and "WITH" only applies to single
+		//licenses anyway)
+		if e.Operator == "AND" && operand.Operator == "OR" {
+			term = "(" + term + ")"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " "+operators[e.Operator]+" ")
+}
+
+var (
+	spdxOperators = map[string]string{"AND": "AND", "OR": "OR", "WITH": "WITH"}
+	//see <https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/#license-syntax>
+	dep5Operators = map[string]string{"AND": "and", "OR": "or", "WITH": "with"}
+)
+
+//LicenseTerms returns the package's license expression split into terms that
+//all apply at the same time (i.e. the operands of the top-level "AND", if
+//any), or nil if the package definition does not declare a license. This is
+//how pacman packages list multiple licenses.
+func (pkg *Package) LicenseTerms() []string {
+	if pkg.License == "" {
+		return nil
+	}
+	expr, err := parseLicenseExpression(pkg.License)
+	if err != nil {
+		return []string{pkg.License} //should not happen since the license was validated by the parser
+	}
+	if expr.Operator != "AND" {
+		return []string{pkg.License}
+	}
+	terms := make([]string, 0, len(expr.Operands))
+	for _, operand := range expr.Operands {
+		terms = append(terms, operand.format(spdxOperators))
+	}
+	return terms
+}
+
+//LicenseFilePath returns the path where package formats following the
+//Filesystem Hierarchy Standard install the package's license file, or "" if
+//the package definition does not declare a license file.
+func (pkg *Package) LicenseFilePath() string {
+	if pkg.LicenseFileName == "" {
+		return ""
+	}
+	return filepath.Join("/usr/share/licenses", pkg.Name, pkg.LicenseFileName)
+}
+
+//InsertLicenseFile adds the license file to the package at LicenseFilePath(),
+//if the package definition declares one.
+func (pkg *Package) InsertLicenseFile() error {
+	path := pkg.LicenseFilePath()
+	if path == "" {
+		return nil
+	}
+	ec := &ErrorCollector{}
+	pkg.InsertFSNode(&FSRegularFile{
+		Content:  pkg.LicenseText,
+		Metadata: FSNodeMetadata{Mode: 0644},
+	}, path, ec)
+	if len(ec.Errors) > 0 {
+		return fmt.Errorf("cannot install license file at %s: %s", path, ec.Errors[0].Error())
+	}
+	return nil
+}
+
+//CopyrightFile returns a copyright file for this package in the
+//machine-readable format from DEP-5, or "" if the package definition does not
+//declare a license. If the package has a license file, its text is included.
+func (pkg *Package) CopyrightFile() string {
+	if pkg.License == "" {
+		return ""
+	}
+	expr, _ := parseLicenseExpression(pkg.License)
+
+	contents := "Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/\n"
+	contents += fmt.Sprintf("Upstream-Name: %s\n", pkg.Name)
+	if pkg.Author != "" {
+		contents += fmt.Sprintf("Upstream-Contact: %s\n", pkg.Author)
+	}
+	contents += "\nFiles: *\n"
+	if pkg.Author != "" {
+		contents += fmt.Sprintf("Copyright: %s\n", pkg.Author)
+	} else {
+		contents += "Copyright: unknown\n"
+	}
+	contents += fmt.Sprintf("License: %s\n", expr.format(dep5Operators))
+
+	//the license text is a continuation of the License field, so indent it
+	//and mark empty lines with " ."
+	text := strings.TrimRight(pkg.LicenseText, "\n")
+	if strings.TrimSpace(text) != "" {
+		for _, line := range strings.Split(text, "\n") {
+			if strings.TrimSpace(line) == "" {
+				contents += " .\n"
+			} else {
+				contents += " " + line + "\n"
+			}
+		}
+	}
+	return contents
+}
@@ -21,53 +21,236 @@
 package common
 
 import (
-	"bytes"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 )
 
-//WriteOutput will write the generated package to a file (or stdout) if
-//required. If the given file name is "-", stdout will be written to.
-//If the given file name is empty, a name is chosen automatically.
-func (pkg *Package) WriteOutput(generator Generator, pkgBytes []byte, pkgFile string, withForce bool) (wasWritten bool, e error) {
-	//print on stdout does not require additional logic
-	if pkgFile == "-" {
-		_, err := os.Stdout.Write(pkgBytes)
-		return false, err
+//OutputFile receives the contents of the generated package (or of another
+//output file) while it is being generated, so that the contents never need to
+//be held in memory as a whole. After all contents have been written, Commit()
+//must be called; or Abort() if the contents could not be generated.
+//
+//The contents are written into a temporary file next to the output file,
+//which replaces the output file only in Commit(), so a failed build never
+//destroys an existing file. If the file name is "-", the contents are spooled
+//and copied to stdout in Commit(). If the file already exists and may not be
+//overwritten, the contents are only hashed and compared to the existing file.
+type OutputFile struct {
+	path           string
+	tempPath       string        //only set when writing a file
+	file           *os.File      //temporary file or spool file; nil when comparing
+	buffer         *bufio.Writer //nil when comparing
+	writer         io.Writer
+	digest         hash.Hash
+	existingDigest string //only set when comparing
+}
+
+//CreateOutputFile prepares writing into the output file with the given name.
+func CreateOutputFile(path string, withForce bool) (*OutputFile, error) {
+	f := &OutputFile{path: path, digest: sha256.New()}
+
+	//print on stdout only after the build was successful
+	if path == "-" {
+		file, err := NewSpoolFile()
+		if err != nil {
+			return nil, err
+		}
+		f.setFile(file)
+		return f, nil
 	}
 
 	//only write file if content has changed
 	if !withForce {
-		fileHandle, err := os.Open(pkgFile)
+		existingDigest, err := fileSHA256Digest(path)
 		if err == nil {
-			defer fileHandle.Close()
-			equal, err := readerEqualTo(fileHandle, pkgBytes)
-			if equal || err != nil {
-				return false, err
+			f.existingDigest = existingDigest
+			f.writer = f.digest
+			return f, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	//the temporary file must be in the same directory, so that it can be
+	//renamed into place
+	dirName, baseName := filepath.Split(path)
+	for idx := 0; ; idx++ {
+		f.tempPath = filepath.Join(dirName, fmt.Sprintf(".%s.%d-%d", baseName, os.Getpid(), idx))
+		file, err := os.OpenFile(f.tempPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			f.setFile(file)
+			return f, nil
+		}
+		if !os.IsExist(err) || idx >= 100 {
+			//report errors for the actual output file, not for the
+			//temporary file (e.g. when the directory does not exist)
+			if pathErr, ok := err.(*os.PathError); ok {
+				pathErr.Path = path
 			}
-			return true, errors.New("file already exists and has different contents; won't overwrite without --force")
+			return nil, err
 		}
+	}
+}
 
-		if !os.IsNotExist(err) {
-			return false, err
+func (f *OutputFile) setFile(file *os.File) {
+	f.file = file
+	f.buffer = bufio.NewWriter(file)
+	f.writer = io.MultiWriter(f.buffer, f.digest)
+}
+
+//Write implements the io.Writer interface.
+func (f *OutputFile) Write(data []byte) (int, error) {
+	return f.writer.Write(data)
+}
+
+//SHA256Digest returns the SHA-256 digest of everything written so far.
+func (f *OutputFile) SHA256Digest() string {
+	return hex.EncodeToString(f.digest.Sum(nil))
+}
+
+//Commit finishes writing the output file. It returns whether the file was
+//written (i.e. false when writing to stdout, or when the existing file
+//already has the same contents).
+func (f *OutputFile) Commit() (wasWritten bool, e error) {
+	if f.existingDigest != "" {
+		if f.existingDigest == f.SHA256Digest() {
+			return false, nil
+		}
+		return true, errors.New("file already exists and has different contents; won't overwrite without --force")
+	}
+
+	err := f.buffer.Flush()
+	if err != nil {
+		f.Abort()
+		return false, err
+	}
+
+	//copy spooled contents to stdout
+	if f.tempPath == "" {
+		defer f.file.Close()
+		_, err = f.file.Seek(0, io.SeekStart)
+		if err == nil {
+			_, err = io.Copy(os.Stdout, f.file)
 		}
+		return false, err
 	}
 
-	return true, ioutil.WriteFile(pkgFile, pkgBytes, 0666)
+	//move temporary file into place
+	err = f.file.Close()
+	if err == nil {
+		err = os.Rename(f.tempPath, f.path)
+	}
+	if err != nil {
+		os.Remove(f.tempPath)
+		return false, err
+	}
+	return true, nil
+}
+
+//Abort discards an incomplete output file. An existing file at the output
+//path is left untouched.
+func (f *OutputFile) Abort() {
+	if f.file != nil {
+		f.file.Close()
+		if f.tempPath != "" {
+			os.Remove(f.tempPath)
+		}
+	}
 }
 
-//Return true if the reader contains exactly the given byte string.
-func readerEqualTo(r io.Reader, str []byte) (bool, error) {
-	buf := make([]byte, len(str))
-	_, err := io.ReadFull(r, buf)
-	switch err {
-	case io.ErrUnexpectedEOF:
-		return false, nil
-	case nil:
-		return bytes.Equal(buf, str), nil
-	default:
+//WriteOutput writes the given contents to a file (or stdout) if required. It
+//is a shorthand for using an OutputFile with contents that are already held
+//in memory.
+func WriteOutput(contents []byte, path string, withForce bool) (wasWritten bool, e error) {
+	f, err := CreateOutputFile(path, withForce)
+	if err != nil {
 		return false, err
 	}
+	_, err = f.Write(contents)
+	if err != nil {
+		f.Abort()
+		return false, err
+	}
+	return f.Commit()
+}
+
+func fileSHA256Digest(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	digest := sha256.New()
+	_, err = io.Copy(digest, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+//fullVersionRx matches the full version strings of packages built by
+//holo-build (see package version validation in the parser).
+var fullVersionRx = regexp.MustCompile(`^(?:[0-9]+:)?[0-9]+(?:\.[0-9]+)*-[0-9]+$`)
+
+//FindNewerBuilds looks for other builds of the same package in the directory
+//where pkgFile is going to be written, and returns the names of those which
+//have a newer version than this package. Package managers would consider an
+//upgrade from one of those to this package a downgrade. Other builds are
+//recognized by the generator's RecommendedFileName(), with a different
+//version in the place of this package's version.
+func (pkg *Package) FindNewerBuilds(generator Generator, pkgFile string) ([]string, error) {
+	cmp, ok := generator.(VersionComparer)
+	if !ok || pkgFile == "-" {
+		return nil, nil
+	}
+
+	//split the recommended file name at the version string
+	ownVersion := cmp.FullVersionString(pkg)
+	fileName := generator.RecommendedFileName(pkg)
+	idx := strings.Index(fileName, ownVersion)
+	if idx < 0 {
+		return nil, nil
+	}
+	prefix := fileName[:idx]
+	suffix := fileName[idx+len(ownVersion):]
+
+	dirName := filepath.Dir(pkgFile)
+	fis, err := ioutil.ReadDir(dirName)
+	if err != nil {
+		//if the directory does not exist, CreateOutputFile() will complain later on
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	var result []string
+	for _, fi := range fis {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() || len(name) <= len(prefix)+len(suffix) {
+			continue
+		}
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		//the version check also ensures that we don't match other packages
+		//whose name starts with this package's name
+		version := strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix)
+		if fullVersionRx.MatchString(version) && cmp.CompareVersions(version, ownVersion) > 0 {
+			result = append(result, filepath.Join(dirName, name))
+		}
+	}
+	return result, nil
 }
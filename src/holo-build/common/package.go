@@ -22,7 +22,9 @@ package common
 
 import (
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
 //Architecture is an enum that describes the target architecture for this
@@ -44,8 +46,42 @@ const (
 	ArchitectureARMv7h
 	// ArchitectureAArch64 = AArch64 (ARMv8 64-bit)
 	ArchitectureAArch64
+	// ArchitecturePPC64LE = 64-bit PowerPC (little endian)
+	ArchitecturePPC64LE
+	// ArchitectureS390X = IBM System z (64-bit)
+	ArchitectureS390X
+	// ArchitectureRISCV64 = 64-bit RISC-V
+	ArchitectureRISCV64
+	// ArchitectureMIPS64EL = 64-bit MIPS (little endian)
+	ArchitectureMIPS64EL
+	// ArchitectureLoong64 = 64-bit LoongArch
+	ArchitectureLoong64
+	// ArchitectureRaw = format-specific architecture string that is passed
+	// through unchanged (given as "raw:$ARCH" in the package definition)
+	ArchitectureRaw
 )
 
+//DescriptionLocales returns the locales for which localized descriptions are
+//available, in sorted order.
+func (pkg *Package) DescriptionLocales() []string {
+	locales := make([]string, 0, len(pkg.LocalizedDescriptions))
+	for locale := range pkg.LocalizedDescriptions {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+//ArchitectureName returns the name of the package's architecture from the
+//given generator-specific map. For ArchitectureRaw, the raw architecture
+//string from the package definition is returned instead.
+func (pkg *Package) ArchitectureName(archMap map[Architecture]string) string {
+	if pkg.Architecture == ArchitectureRaw {
+		return strings.TrimPrefix(pkg.ArchitectureInput, "raw:")
+	}
+	return archMap[pkg.Architecture]
+}
+
 //Package contains all information about a single package. This representation
 //will be passed into the generator backends.
 type Package struct {
@@ -70,36 +106,111 @@ type Package struct {
 	Epoch uint
 	//Description is the optional package description.
 	Description string
+	//LocalizedDescriptions contains translations of the Description, indexed
+	//by locale (e.g. "de" or "pt_BR").
+	LocalizedDescriptions map[string]string
 	//Author contains the package's author's name and mail address in the form
 	//"Firstname Lastname <email.address@server.tld>", if this information is
 	//available.
 	Author string
+	//License is the SPDX license expression for the package contents (e.g.
+	//"MIT OR Apache-2.0"), or empty if not known.
+	License string
+	//LicenseFileName is the file name of the license file (e.g. "LICENSE"),
+	//or empty if the package definition does not reference one.
+	LicenseFileName string
+	//LicenseText is the contents of the license file.
+	LicenseText string
 	//Architecture specifies the target architecture of this package (usually
 	//"any" since holo-build packages tend to not include compiled binaries).
 	Architecture Architecture
 	//ArchitectureInput contains the raw architecture string specified by the
-	//user (used only for error messages).
+	//user (used only for error messages), or the name of the derived
+	//architecture if "auto" was specified.
 	ArchitectureInput string
 	//Requires contains a list of other packages that are required dependencies
 	//for this package and thus must be installed together with this package.
 	//This is called "Depends" by some package managers.
 	Requires []PackageRelation
+	//PreDepends contains a list of other packages that must be fully
+	//installed before this package can be installed (not just at the same
+	//time), e.g. because they are needed by this package's setup actions.
+	PreDepends []PackageRelation
 	//Provides contains a list of packages that this package provides features
 	//of (or virtual packages whose capabilities it implements).
 	Provides []PackageRelation
 	//Conflicts contains a list of other packages that cannot be installed at
 	//the same time as this package.
 	Conflicts []PackageRelation
+	//Breaks contains a list of other packages that stop working when this
+	//package is installed. Unlike Conflicts, both packages may be unpacked at
+	//the same time, but the broken packages must be upgraded or removed before
+	//this package is configured. Package formats without this distinction
+	//treat Breaks like Conflicts.
+	Breaks []PackageRelation
 	//Replaces contains a list of obsolete packages that are replaced by this
 	//package. Upon performing a system upgrade, the obsolete packages will be
 	//automatically replaced by this package.
 	Replaces []PackageRelation
+	//RelationMap contains the relation map referenced by the package
+	//definition, if any (see MapRelationNames).
+	RelationMap RelationMap
+	//AutoSharedLibraries is true if relations to shared libraries shall be
+	//generated from the ELF binaries in this package (see
+	//AddSharedLibraryRelations).
+	AutoSharedLibraries bool
+	//SharedLibraryMap maps sonames of shared libraries to package names (in
+	//the same format as the RelationMap).
+	SharedLibraryMap RelationMap
+	//AutoInterpreters is true if requirements on the interpreters of scripts
+	//shall be generated from their shebang lines (see
+	//AddInterpreterRelations).
+	AutoInterpreters bool
+	//InterpreterMap maps paths of interpreters to package names (in the same
+	//format as the RelationMap).
+	InterpreterMap RelationMap
 	//Actions contains a list of actions that can be executed while the package
 	//manager runs.
 	Actions []PackageAction
+	//Diversions contains the absolute paths of files in this package that
+	//replace files owned by other packages. The original files are moved out
+	//of the way while this package is installed (cf. dpkg-divert(1)).
+	Diversions []string
+	//ConffileMigrations contains a list of configuration files that were
+	//renamed or removed in some version of this package.
+	ConffileMigrations []ConffileMigration
+	//Changelog contains the changelog of this package, newest entry first.
+	Changelog []ChangelogEntry
+	//Alternatives contains a list of entries for the alternatives system
+	//(update-alternatives(1)) that this package registers.
+	Alternatives []Alternative
+	//Compression is the compression format for the package contents, or
+	//CompressionDefault to use the default of the package format.
+	Compression Compression
+	//CompressionOptions contains the compression level and the number of
+	//threads for compressing the package contents.
+	CompressionOptions CompressionOptions
 	//FSRoot represents the root directory of the package's file system, and
 	//contains all other files and directories recursively.
 	FSRoot *FSDirectory
+	//DefinitionDigest is the SHA-256 digest of the package definition, as it
+	//was read from the input.
+	DefinitionDigest string
+	//SourceFiles contains the files that were read because the package
+	//definition references them (with contentFrom, licenseFile or one of the
+	//relation maps), or because they were given on the command line (with
+	//--relation-map).
+	SourceFiles []SourceFile
+}
+
+//SourceFile describes a file that was read while parsing the package
+//definition.
+type SourceFile struct {
+	//Path is the path as given in the package definition (or on the command
+	//line).
+	Path string
+	//SHA256 is the hex-encoded SHA-256 digest of the file contents.
+	SHA256 string
 }
 
 //PackageRelation declares a relation to another package. For the related
@@ -114,9 +225,35 @@ type Package struct {
 //            VersionConstraint { "<",  "3.0"   },
 //        }
 //    }
+//
+//A relation can also be a choice between multiple packages, e.g. "requires
+//openssh-server or dropbear". In this case, RelatedPackage and Constraints are
+//empty, and the relation is satisfied when any one of the Alternatives is
+//satisfied:
+//
+//    pkg.Requires := []PackageRelation{
+//        PackageRelation { Alternatives: []PackageRelation{
+//            PackageRelation { "openssh-server", nil },
+//            PackageRelation { "dropbear", nil },
+//        }
+//    }
+//
+//Alternatives are only allowed in Requires and PreDepends, and they cannot be
+//nested.
 type PackageRelation struct {
 	RelatedPackage string
 	Constraints    []VersionConstraint
+	Alternatives   []PackageRelation
+	//Origin is empty for relations from the package definition. For
+	//relations that were generated by holo-build, it describes where they
+	//come from (for use in error messages).
+	Origin string
+}
+
+//HasAlternatives returns true if this relation is a choice between multiple
+//packages (see documentation for PackageRelation).
+func (r PackageRelation) HasAlternatives() bool {
+	return len(r.Alternatives) > 0
 }
 
 //VersionConstraint is used by the PackageRelation struct to specify version
@@ -131,6 +268,50 @@ type VersionConstraint struct {
 	Version string
 }
 
+//Alternative describes an entry for the alternatives system
+//(update-alternatives(1)), i.e. one out of multiple competing files that can
+//be selected by a generic symlink.
+type Alternative struct {
+	//Name is the name of the link group, e.g. "editor".
+	Name string
+	//Link is the path of the generic symlink, e.g. "/usr/bin/editor".
+	Link string
+	//Path is the path of the file that this package offers as an
+	//alternative, e.g. "/usr/bin/vim".
+	Path string
+	//Priority decides which alternative is selected automatically (the one
+	//with the highest priority wins).
+	Priority int
+}
+
+//ConffileMigration describes a configuration file that was renamed or removed
+//in some version of a package. When upgrading from an older version, changes
+//made by the user to the old file shall be retained.
+type ConffileMigration struct {
+	//From is the path of the configuration file in older versions.
+	From string
+	//To is the path of the configuration file in newer versions, or empty if
+	//the file was removed.
+	To string
+	//Since is the first package version (without epoch and release) in which
+	//the file was renamed or removed. The package's epoch is implied.
+	Since string
+}
+
+//ChangelogEntry describes the changes in one version of a package.
+type ChangelogEntry struct {
+	//Version is the version that this entry describes. Unlike Package.Version,
+	//this may include an epoch and a release, e.g. "1:1.2.0-2".
+	Version string
+	//Date is the (UTC) time when the version was released.
+	Date time.Time
+	//Author is the person who made this version, in the same form as
+	//Package.Author.
+	Author string
+	//Entries contains one line (or paragraph) per change.
+	Entries []string
+}
+
 //PackageAction describes an action that can be executed by the package manager
 //at various points during its execution.
 type PackageAction struct {
@@ -149,6 +330,18 @@ const (
 	//CleanupAction is an acceptable value for `PackageAction.Type`. Cleanup
 	//actions run immediately after the package has been removed from a system.
 	CleanupAction
+	//PreCleanupAction is an acceptable value for `PackageAction.Type`.
+	//Pre-cleanup actions run immediately before the package is removed from a
+	//system (but not when it is upgraded), while its files are still present.
+	//This type cannot be selected in package definitions; it is only used by
+	//the generators.
+	PreCleanupAction
+	//PurgeAction is an acceptable value for `PackageAction.Type`. Purge
+	//actions run after the package has been removed from a system for good,
+	//i.e. when all traces of it shall be removed. For package formats that
+	//do not distinguish between removal and purging, they run after the final
+	//removal (but not on upgrade).
+	PurgeAction
 )
 
 //PrependActions prepends elements to p.Actions.
@@ -199,3 +392,21 @@ func (p *Package) WalkFSWithAbsolutePaths(callback func(absolutePath string, nod
 func (p *Package) WalkFSWithRelativePaths(callback func(relativePath string, node FSNode) error) error {
 	return p.FSRoot.Walk("", callback)
 }
+
+//IsConfigFile returns whether the file at the given absolute path is a
+//configuration file, which the package manager shall preserve when the user
+//has modified it. These are all files below /etc (where the Filesystem
+//Hierarchy Standard places configuration files), and the new paths of
+//ConffileMigrations. (A migration only retains the user's changes if the old
+//package also marked the old path as a configuration file.)
+func (p *Package) IsConfigFile(absolutePath string) bool {
+	if strings.HasPrefix(absolutePath, "/etc/") {
+		return true
+	}
+	for _, m := range p.ConffileMigrations {
+		if m.To == absolutePath {
+			return true
+		}
+	}
+	return false
+}
@@ -28,8 +28,10 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
@@ -37,13 +39,17 @@ import (
 //PackageDefinition only needs a nice exported name for the TOML parser to
 //produce more meaningful error messages on malformed input data.
 type PackageDefinition struct {
-	Package   PackageSection
-	File      []FileSection
-	Directory []DirectorySection
-	Symlink   []SymlinkSection
-	Action    []ActionSection
-	User      []UserSection  //see common/entities.go
-	Group     []GroupSection //see common/entities.go
+	Package           PackageSection
+	File              []FileSection
+	Directory         []DirectorySection
+	Symlink           []SymlinkSection
+	Ghost             []GhostSection
+	Action            []ActionSection
+	Alternative       []AlternativeSection
+	ConffileMigration []ConffileMigrationSection
+	Changelog         []ChangelogSection
+	User              []UserSection  //see common/entities.go
+	Group             []GroupSection //see common/entities.go
 }
 
 //PackageSection only needs a nice exported name for the TOML parser to produce
@@ -53,16 +59,28 @@ type PackageSection struct {
 	Version        string
 	Release        uint
 	Epoch          uint
-	Description    string
+	Description    interface{} //either string or table of strings (see parseDescription)
 	Author         string
+	License        string
+	LicenseFile    string
 	Architecture   string
 	Requires       []string
+	PreDepends     []string
 	Provides       []string
 	Conflicts      []string
+	Breaks         []string
 	Replaces       []string
 	SetupScript    string
 	CleanupScript  string
 	DefinitionFile string //see compileEntityDefinitions
+	RelationMap    string
+	//see common/sharedlibs.go
+	AutoSharedLibraries bool
+	SharedLibraryMap    string
+	//see common/interpreters.go
+	AutoInterpreters bool
+	InterpreterMap   string
+	Compression      string //see common/compress.go
 }
 
 //FileSection only needs a nice exported name for the TOML parser to produce
@@ -72,6 +90,7 @@ type FileSection struct {
 	Content     string
 	ContentFrom string
 	Raw         bool
+	Divert      bool
 	Mode        string      //TOML does not support octal number literals, so we have to write: mode = "0666"
 	Owner       interface{} //either string (name) or integer (ID)
 	Group       interface{} //same
@@ -98,6 +117,15 @@ type SymlinkSection struct {
 	Target string
 }
 
+//GhostSection only needs a nice exported name for the TOML parser to produce
+//more meaningful error messages on malformed input data.
+type GhostSection struct {
+	Path  string
+	Mode  string      //see above
+	Owner interface{} //see above
+	Group interface{} //see above
+}
+
 //ActionSection only needs a nice exported name for the TOML parser to produce
 //more meaningful error messages on malformed input data.
 type ActionSection struct {
@@ -105,6 +133,32 @@ type ActionSection struct {
 	Script string
 }
 
+//AlternativeSection only needs a nice exported name for the TOML parser to
+//produce more meaningful error messages on malformed input data.
+type AlternativeSection struct {
+	Name     string
+	Link     string
+	Path     string
+	Priority int64
+}
+
+//ConffileMigrationSection only needs a nice exported name for the TOML parser
+//to produce more meaningful error messages on malformed input data.
+type ConffileMigrationSection struct {
+	From  string
+	To    string
+	Since string
+}
+
+//ChangelogSection only needs a nice exported name for the TOML parser to
+//produce more meaningful error messages on malformed input data.
+type ChangelogSection struct {
+	Version string
+	Date    string
+	Author  string
+	Entries []string
+}
+
 //versions are dot-separated numbers like (0|[1-9][0-9]*) (this enforces no
 //trailing zeros)
 var versionRx = regexp.MustCompile(`^(?:0|[1-9][0-9]*)(?:\.(?:0|[1-9][0-9]*))*$`)
@@ -112,27 +166,42 @@ var versionRx = regexp.MustCompile(`^(?:0|[1-9][0-9]*)(?:\.(?:0|[1-9][0-9]*))*$`
 //the author information should be in the form "Firstname Lastname <email.address@server.tld>"
 var authorRx = regexp.MustCompile(`^[^<>]+\s+<[^<>\s]+>$`)
 
+//raw architecture strings ("raw:$ARCH") are passed through to the generator
+//unchanged, so only allow characters that are safe in file names
+var rawArchRx = regexp.MustCompile(`^[a-zA-Z0-9_]+(?:-[a-zA-Z0-9_]+)*$`)
+
+//locales for localized descriptions look like "de" or "pt_BR"
+var localeRx = regexp.MustCompile(`^[a-z]{2,3}(?:_[A-Z]{2})?$`)
+
 //map supported input strings for architecture to internal architecture enum;
 //the "BEGIN ARCH" and "END ARCH" comments are used by test/generate-architecture-tests.sh
 var archMap = map[string]Architecture{
 	//BEGIN ARCH
-	"aarch64": ArchitectureAArch64,
-	"all":     ArchitectureAny, //from Debian
-	"amd64":   ArchitectureX86_64,
-	"any":     ArchitectureAny,     //from Arch Linux
-	"arm":     ArchitectureARMv5,   //from Arch Linux
-	"arm64":   ArchitectureAArch64, //from Debian
-	"armel":   ArchitectureARMv5,   //from Debian
-	"armhf":   ArchitectureARMv7h,  //from Debian
-	"armv5tl": ArchitectureARMv5,   //from Mageia
-	"armv6h":  ArchitectureARMv6h,  //from Arch Linux
-	"armv6hl": ArchitectureARMv6h,  //from OpenSuse
-	"armv7h":  ArchitectureARMv7h,  //from Arch Linux
-	"armv7hl": ArchitectureARMv7h,  //from OpenSuse
-	"i386":    ArchitectureI386,
-	"i686":    ArchitectureI386,
-	"noarch":  ArchitectureAny, //from RPM
-	"x86_64":  ArchitectureX86_64,
+	"aarch64":     ArchitectureAArch64,
+	"all":         ArchitectureAny, //from Debian
+	"amd64":       ArchitectureX86_64,
+	"any":         ArchitectureAny,     //from Arch Linux
+	"arm":         ArchitectureARMv5,   //from Arch Linux
+	"arm64":       ArchitectureAArch64, //from Debian
+	"armel":       ArchitectureARMv5,   //from Debian
+	"armhf":       ArchitectureARMv7h,  //from Debian
+	"armv5tl":     ArchitectureARMv5,   //from Mageia
+	"armv6h":      ArchitectureARMv6h,  //from Arch Linux
+	"armv6hl":     ArchitectureARMv6h,  //from OpenSuse
+	"armv7h":      ArchitectureARMv7h,  //from Arch Linux
+	"armv7hl":     ArchitectureARMv7h,  //from OpenSuse
+	"i386":        ArchitectureI386,
+	"i686":        ArchitectureI386,
+	"loong64":     ArchitectureLoong64, //from Debian
+	"loongarch64": ArchitectureLoong64, //from Fedora
+	"mips64el":    ArchitectureMIPS64EL,
+	"noarch":      ArchitectureAny,     //from RPM
+	"powerpc64le": ArchitecturePPC64LE, //from Arch POWER
+	"ppc64el":     ArchitecturePPC64LE, //from Debian
+	"ppc64le":     ArchitecturePPC64LE, //from Fedora
+	"riscv64":     ArchitectureRISCV64,
+	"s390x":       ArchitectureS390X,
+	"x86_64":      ArchitectureX86_64,
 	//END ARCH
 }
 
@@ -156,13 +225,13 @@ func ParsePackageDefinition(input io.Reader, baseDirectory string) (*Package, []
 		Version:           strings.TrimSpace(p.Package.Version),
 		Release:           p.Package.Release,
 		Epoch:             p.Package.Epoch,
-		Description:       strings.TrimSpace(p.Package.Description),
 		Author:            strings.TrimSpace(p.Package.Author),
 		ArchitectureInput: p.Package.Architecture,
 		Actions:           []PackageAction{},
 		FSRoot:            NewFSDirectory(),
 	}
 	pkg.FSRoot.Implicit = true
+	pkg.DefinitionDigest = sha256Digest(blob)
 
 	if script := strings.TrimSpace(p.Package.SetupScript); script != "" {
 		WarnDeprecatedKey("package.setupScript")
@@ -202,6 +271,7 @@ func ParsePackageDefinition(input io.Reader, baseDirectory string) (*Package, []
 		ec.Addf("Invalid package version \"%s\" (must be a chain of numbers like \"1.2.0\" or \"20151104\")", pkg.Version)
 		pkg.Version = "" // don't complain about the broken value again in generator.Validate()
 	}
+	pkg.Description, pkg.LocalizedDescriptions = parseDescription(p.Package.Description, ec)
 	if strings.ContainsAny(pkg.Description, "\r\n") {
 		ec.Addf("Invalid package description \"%s\" (may not contain newlines)", pkg.Name)
 		pkg.Description = "" // don't complain about the broken value again in generator.Validate()
@@ -212,8 +282,51 @@ func ParsePackageDefinition(input io.Reader, baseDirectory string) (*Package, []
 		ec.Addf("Invalid package author \"%s\" (should look like \"Jane Doe <jane.doe@example.org>\")", pkg.Author)
 	}
 
+	//parse license (see common/license.go)
+	if p.Package.License != "" {
+		expr, err := parseLicenseExpression(p.Package.License)
+		if err == nil {
+			pkg.License = expr.format(spdxOperators)
+		} else {
+			ec.Addf("Invalid package license \"%s\" (not a valid SPDX license expression: %s)", p.Package.License, err.Error())
+		}
+	}
+	if path := p.Package.LicenseFile; path != "" {
+		if p.Package.License == "" {
+			ec.Addf("package.licenseFile requires package.license")
+		}
+		if !strings.HasPrefix(path, "/") {
+			//resolve relative paths
+			path = filepath.Join(baseDirectory, path)
+		}
+		text, err := ioutil.ReadFile(path)
+		ec.Add(err)
+		pkg.LicenseText = string(text)
+		pkg.LicenseFileName = filepath.Base(path)
+		pkg.SourceFiles = append(pkg.SourceFiles, SourceFile{p.Package.LicenseFile, sha256Digest(text)})
+	}
+
+	if p.Package.Compression != "" {
+		pkg.Compression, err = ParseCompression(p.Package.Compression)
+		if err != nil {
+			ec.Addf("Invalid package compression: %s", err.Error())
+		}
+	}
+
 	//parse architecture string
-	if p.Package.Architecture != "" {
+	deriveArchitecture := false
+	switch {
+	case p.Package.Architecture == "":
+		//use default value
+	case p.Package.Architecture == "auto":
+		//will be derived from ELF binaries below
+		deriveArchitecture = true
+	case strings.HasPrefix(p.Package.Architecture, "raw:"):
+		pkg.Architecture = ArchitectureRaw
+		if !rawArchRx.MatchString(strings.TrimPrefix(p.Package.Architecture, "raw:")) {
+			ec.Addf("Invalid package architecture \"%s\"", p.Package.Architecture)
+		}
+	default:
 		var ok bool
 		pkg.Architecture, ok = archMap[p.Package.Architecture]
 		if !ok {
@@ -223,10 +336,77 @@ func ParsePackageDefinition(input io.Reader, baseDirectory string) (*Package, []
 
 	//parse relations to other packages
 	pkg.Requires = parseRelatedPackages("requires", p.Package.Requires, ec)
+	pkg.PreDepends = parseRelatedPackages("predepends", p.Package.PreDepends, ec)
 	pkg.Provides = parseRelatedPackages("provides", p.Package.Provides, ec)
 	pkg.Conflicts = parseRelatedPackages("conflicts", p.Package.Conflicts, ec)
+	pkg.Breaks = parseRelatedPackages("breaks", p.Package.Breaks, ec)
 	pkg.Replaces = parseRelatedPackages("replaces", p.Package.Replaces, ec)
 
+	//read relation map (this is applied by the caller since the package
+	//format is not known here)
+	if path := p.Package.RelationMap; path != "" {
+		if !strings.HasPrefix(path, "/") {
+			//resolve relative paths
+			path = filepath.Join(baseDirectory, path)
+		}
+		var (
+			source SourceFile
+			err    error
+		)
+		pkg.RelationMap, source, err = ReadRelationMap(path)
+		ec.Add(err)
+		if err == nil {
+			source.Path = p.Package.RelationMap
+			pkg.SourceFiles = append(pkg.SourceFiles, source)
+		}
+	}
+
+	//read shared library map (this is also applied by the caller, in
+	//AddSharedLibraryRelations)
+	pkg.AutoSharedLibraries = p.Package.AutoSharedLibraries
+	if path := p.Package.SharedLibraryMap; path != "" {
+		if !strings.HasPrefix(path, "/") {
+			//resolve relative paths
+			path = filepath.Join(baseDirectory, path)
+		}
+		var (
+			source SourceFile
+			err    error
+		)
+		pkg.SharedLibraryMap, source, err = ReadRelationMap(path)
+		ec.Add(err)
+		if err == nil {
+			source.Path = p.Package.SharedLibraryMap
+			pkg.SourceFiles = append(pkg.SourceFiles, source)
+		}
+		if !pkg.AutoSharedLibraries {
+			ec.Addf("package.sharedLibraryMap requires package.autoSharedLibraries = true")
+		}
+	}
+
+	//read interpreter map (this is also applied by the caller, in
+	//AddInterpreterRelations)
+	pkg.AutoInterpreters = p.Package.AutoInterpreters
+	if path := p.Package.InterpreterMap; path != "" {
+		if !strings.HasPrefix(path, "/") {
+			//resolve relative paths
+			path = filepath.Join(baseDirectory, path)
+		}
+		var (
+			source SourceFile
+			err    error
+		)
+		pkg.InterpreterMap, source, err = ReadRelationMap(path)
+		ec.Add(err)
+		if err == nil {
+			source.Path = p.Package.InterpreterMap
+			pkg.SourceFiles = append(pkg.SourceFiles, source)
+		}
+		if !pkg.AutoInterpreters {
+			ec.Addf("package.interpreterMap requires package.autoInterpreters = true")
+		}
+	}
+
 	//compile entity definition file
 	entityNode, entityPath := compileEntityDefinitions(p.Package, p.Group, p.User, ec)
 	if entityNode != nil && entityPath != "" {
@@ -263,17 +443,25 @@ func ParsePackageDefinition(input io.Reader, baseDirectory string) (*Package, []
 		isPathValid := validatePath(path, ec, "file", idx)
 
 		entryDesc := fmt.Sprintf("file \"%s\"", path)
-		node := &FSRegularFile{
-			Content: parseFileContent(fileSection.Content, fileSection.ContentFrom, fileSection.Raw, baseDirectory, ec, entryDesc),
-			Metadata: FSNodeMetadata{
-				Mode:  parseFileMode(fileSection.Mode, 0644, ec, entryDesc),
-				Owner: parseUserOrGroupRef(fileSection.Owner, ec, entryDesc),
-				Group: parseUserOrGroupRef(fileSection.Group, ec, entryDesc),
-			},
+		node := parseFileContent(fileSection.Content, fileSection.ContentFrom, fileSection.Raw, baseDirectory, ec, entryDesc)
+		node.Metadata = FSNodeMetadata{
+			Mode:  parseFileMode(fileSection.Mode, 0644, ec, entryDesc),
+			Owner: parseUserOrGroupRef(fileSection.Owner, ec, entryDesc),
+			Group: parseUserOrGroupRef(fileSection.Group, ec, entryDesc),
+		}
+		if fileSection.ContentFrom != "" && fileSection.Content == "" {
+			pkg.SourceFiles = append(pkg.SourceFiles, SourceFile{fileSection.ContentFrom, node.SHA256Digest()})
 		}
 		if isPathValid {
 			pkg.InsertFSNode(node, path, ec)
 		}
+		if fileSection.Divert && isPathValid {
+			if scriptablePathRx.MatchString(path) {
+				pkg.Diversions = append(pkg.Diversions, path)
+			} else {
+				ec.Addf("%s is invalid: cannot be diverted because the path may only contain letters, digits, slashes and the characters \"_.+@:-\"", entryDesc)
+			}
+		}
 	}
 
 	for idx, symlinkSection := range p.Symlink {
@@ -290,9 +478,184 @@ func ParsePackageDefinition(input io.Reader, baseDirectory string) (*Package, []
 		}
 	}
 
+	for idx, ghostSection := range p.Ghost {
+		path := ghostSection.Path
+		isPathValid := validatePath(path, ec, "ghost", idx)
+
+		//ghost files need to be cleaned up by maintainer scripts for some
+		//package formats
+		if isPathValid && !scriptablePathRx.MatchString(path) {
+			ec.Addf("ghost \"%s\" is invalid: path may only contain letters, digits, slashes and the characters \"_.+@:-\"", path)
+			isPathValid = false
+		}
+
+		entryDesc := fmt.Sprintf("ghost \"%s\"", path)
+		node := &FSGhostFile{
+			Metadata: FSNodeMetadata{
+				Mode:  parseFileMode(ghostSection.Mode, 0644, ec, entryDesc),
+				Owner: parseUserOrGroupRef(ghostSection.Owner, ec, entryDesc),
+				Group: parseUserOrGroupRef(ghostSection.Group, ec, entryDesc),
+			},
+		}
+		if isPathValid {
+			pkg.InsertFSNode(node, path, ec)
+		}
+	}
+
+	//check that compiled binaries match the architecture (or derive the
+	//architecture from them)
+	pkg.checkELFBinaries(deriveArchitecture, ec)
+
+	//parse and validate alternatives (this needs to come after the FS entries
+	//since it checks for collisions with them)
+	namesSeen := make(map[string]bool)
+	for idx, altSection := range p.Alternative {
+		alt, isValid := parseAlternative(altSection, &pkg, ec, idx)
+		if isValid && namesSeen[alt.Name] {
+			ec.Addf("alternative \"%s\" is invalid: duplicate name", alt.Name)
+			isValid = false
+		}
+		if isValid {
+			namesSeen[alt.Name] = true
+			pkg.Alternatives = append(pkg.Alternatives, alt)
+		}
+	}
+
+	for idx, migrationSection := range p.ConffileMigration {
+		migration, isValid := parseConffileMigration(migrationSection, &pkg, ec, idx)
+		if isValid {
+			pkg.ConffileMigrations = append(pkg.ConffileMigrations, migration)
+		}
+	}
+
+	for idx, changelogSection := range p.Changelog {
+		entry, isValid := parseChangelogEntry(changelogSection, &pkg, ec, idx)
+		if !isValid {
+			continue
+		}
+		//package managers expect the newest entry first
+		if count := len(pkg.Changelog); count > 0 && entry.Date.After(pkg.Changelog[count-1].Date) {
+			ec.Addf("changelog \"%s\" is invalid: changelog entries must be sorted from newest to oldest", entry.Version)
+		}
+		pkg.Changelog = append(pkg.Changelog, entry)
+	}
+
 	return &pkg, ec.Errors
 }
 
+//changelog versions may include epoch and release, unlike package versions
+var changelogVersionRx = regexp.MustCompile(`^(?:[0-9]+:)?(?:0|[1-9][0-9]*)(?:\.(?:0|[1-9][0-9]*))*(?:-[1-9][0-9]*)?$`)
+
+func parseChangelogEntry(data ChangelogSection, pkg *Package, ec *ErrorCollector, entryIdx int) (entry ChangelogEntry, isValid bool) {
+	entry = ChangelogEntry{
+		Version: strings.TrimSpace(data.Version),
+		Author:  strings.TrimSpace(data.Author),
+	}
+	if entry.Version == "" {
+		ec.Addf("changelog %d is invalid: missing \"version\" attribute", entryIdx)
+		return entry, false
+	}
+	entryDesc := fmt.Sprintf("changelog \"%s\"", entry.Version)
+	isValid = true
+
+	if !changelogVersionRx.MatchString(entry.Version) {
+		ec.Addf("%s is invalid: \"version\" must be a version like \"1.2.0\" or \"1:1.2.0-2\"", entryDesc)
+		isValid = false
+	}
+
+	//dates come from the package definition (instead of the clock) to keep
+	//builds reproducible
+	var err error
+	switch {
+	case data.Date == "":
+		ec.Addf("%s is invalid: missing \"date\" attribute", entryDesc)
+		isValid = false
+	case strings.Contains(data.Date, "T"):
+		entry.Date, err = time.Parse(time.RFC3339, data.Date)
+	default:
+		entry.Date, err = time.Parse("2006-01-02", data.Date)
+	}
+	if err != nil {
+		ec.Addf("%s is invalid: \"date\" must look like \"2018-03-14\" or \"2018-03-14T12:00:00Z\", found \"%s\"", entryDesc, data.Date)
+		isValid = false
+	}
+	entry.Date = entry.Date.UTC()
+
+	//the author defaults to the package author
+	if entry.Author == "" {
+		entry.Author = pkg.Author
+	}
+	switch {
+	case entry.Author == "":
+		ec.Addf("%s is invalid: missing \"author\" attribute (required if package.author is not given)", entryDesc)
+		isValid = false
+	case !authorRx.MatchString(entry.Author):
+		ec.Addf("%s is invalid: \"author\" should look like \"Jane Doe <jane.doe@example.org>\"", entryDesc)
+		isValid = false
+	}
+
+	//the generators do their own indentation for multi-line entries
+	for _, text := range data.Entries {
+		lines := strings.Split(strings.TrimSpace(text), "\n")
+		for idx, line := range lines {
+			lines[idx] = strings.TrimSpace(line)
+		}
+		if text = strings.Join(lines, "\n"); text != "" {
+			entry.Entries = append(entry.Entries, text)
+		}
+	}
+	if len(entry.Entries) == 0 {
+		ec.Addf("%s is invalid: missing \"entries\" attribute", entryDesc)
+		isValid = false
+	}
+
+	return entry, isValid
+}
+
+//parseDescription reads package.description, which is either a string, or a
+//table of strings with one entry per locale and a "default" entry for all
+//other locales.
+func parseDescription(value interface{}, ec *ErrorCollector) (string, map[string]string) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return strings.TrimSpace(value), nil
+	case map[string]interface{}:
+		//sort locales to report errors in a reproducible order
+		locales := make([]string, 0, len(value))
+		for locale := range value {
+			locales = append(locales, locale)
+		}
+		sort.Strings(locales)
+
+		var desc string
+		localized := make(map[string]string)
+		for _, locale := range locales {
+			str, ok := value[locale].(string)
+			switch {
+			case !ok:
+				ec.Addf("Invalid package description for locale \"%s\" (must be a string)", locale)
+			case locale == "default":
+				desc = strings.TrimSpace(str)
+			case !localeRx.MatchString(locale):
+				ec.Addf("Invalid locale \"%s\" in package description (should look like \"de\" or \"pt_BR\")", locale)
+			case strings.ContainsAny(str, "\r\n"):
+				ec.Addf("Invalid package description for locale \"%s\" (may not contain newlines)", locale)
+			default:
+				localized[locale] = strings.TrimSpace(str)
+			}
+		}
+		if _, exists := value["default"]; !exists {
+			ec.Addf("Localized package description is missing the \"default\" entry")
+		}
+		return desc, localized
+	default:
+		ec.Addf("Invalid package description (must be a string or a table of strings)")
+		return "", nil
+	}
+}
+
 //relatedPackageRx and providesPackageRx are nearly identical, except that for a "provides" relation, only the operator "=" is acceptable
 var relatedPackageRx = regexp.MustCompile(`^([^\s<=>]+)\s*(?:(<=?|>=?|=)\s*([^\s<=>]+))?$`)
 var providesPackageRx = regexp.MustCompile(`^([^\s<=>]+)\s*(?:(=)\s*([^\s<=>]+))?$`)
@@ -308,6 +671,21 @@ func parseRelatedPackages(relType string, specs []string, ec *ErrorCollector) []
 			rx = providesPackageRx
 		}
 
+		//alternatives like "foo | bar" are not merged with other relations
+		if strings.Contains(spec, "|") {
+			if relType != "requires" && relType != "predepends" {
+				ec.Addf("Invalid package reference in %s: \"%s\" (alternatives are only allowed in requires and predepends)", relType, spec)
+				continue
+			}
+			rel, ok := parseAlternatives(spec, rx)
+			if !ok {
+				ec.Addf("Invalid package reference in %s: \"%s\"", relType, spec)
+				continue
+			}
+			rels = append(rels, rel)
+			continue
+		}
+
 		//check format of spec
 		match := rx.FindStringSubmatch(spec)
 		if match == nil {
@@ -335,10 +713,28 @@ func parseRelatedPackages(relType string, specs []string, ec *ErrorCollector) []
 	return rels
 }
 
+//parseAlternatives parses a relation like "foo >= 2.0 | bar" into a
+//PackageRelation with Alternatives.
+func parseAlternatives(spec string, rx *regexp.Regexp) (rel PackageRelation, ok bool) {
+	for _, alternative := range strings.Split(spec, "|") {
+		match := rx.FindStringSubmatch(strings.TrimSpace(alternative))
+		if match == nil {
+			return PackageRelation{}, false
+		}
+		altRel := PackageRelation{RelatedPackage: match[1]}
+		if match[2] != "" {
+			altRel.Constraints = []VersionConstraint{{Relation: match[2], Version: match[3]}}
+		}
+		rel.Alternatives = append(rel.Alternatives, altRel)
+	}
+	return rel, true
+}
+
 //maps string values of "action.on" to internal enum values
 var actionTypeMap = map[string]uint{
 	"setup":   SetupAction,
 	"cleanup": CleanupAction,
+	"purge":   PurgeAction,
 }
 
 func parseAction(data ActionSection, ec *ErrorCollector, entryIdx int) (action PackageAction, isValid bool) {
@@ -359,6 +755,120 @@ func parseAction(data ActionSection, ec *ErrorCollector, entryIdx int) (action P
 	return
 }
 
+//the name of an alternative is used as a file name by update-alternatives(1)
+var alternativeNameRx = regexp.MustCompile(`^[a-zA-Z0-9_.+-]+$`)
+
+//paths that end up in maintainer scripts unquoted (for alternatives and
+//diversions) must be restricted to harmless characters
+var scriptablePathRx = regexp.MustCompile(`^(?:/[a-zA-Z0-9_.+@:-]+)+$`)
+
+func parseAlternative(data AlternativeSection, pkg *Package, ec *ErrorCollector, entryIdx int) (alt Alternative, isValid bool) {
+	alt = Alternative{
+		Name:     strings.TrimSpace(data.Name),
+		Link:     data.Link,
+		Path:     data.Path,
+		Priority: int(data.Priority),
+	}
+	isValid = true
+
+	switch {
+	case alt.Name == "":
+		ec.Addf("alternative %d is invalid: missing \"name\" attribute", entryIdx)
+		return alt, false
+	case !alternativeNameRx.MatchString(alt.Name):
+		ec.Addf("alternative \"%s\" is invalid: name may only contain letters, digits and the characters \"_.+-\"", alt.Name)
+		isValid = false
+	}
+
+	entryDesc := fmt.Sprintf("alternative \"%s\"", alt.Name)
+	for _, field := range []struct{ Key, Value string }{{"link", alt.Link}, {"path", alt.Path}} {
+		switch {
+		case field.Value == "":
+			ec.Addf("%s is invalid: missing \"%s\" attribute", entryDesc, field.Key)
+			isValid = false
+		case !scriptablePathRx.MatchString(field.Value):
+			ec.Addf("%s is invalid: \"%s\" must be an absolute path without trailing slash, consisting only of letters, digits and the characters \"_.+@:-\"", entryDesc, field.Key)
+			isValid = false
+		}
+	}
+
+	if data.Priority < 0 || data.Priority >= 1<<31 {
+		ec.Addf("%s is invalid: priority %d is out of range", entryDesc, data.Priority)
+		isValid = false
+	}
+
+	//the link is managed by the alternatives system, so the package may not
+	//contain anything at that path
+	if isValid {
+		pkg.WalkFSWithAbsolutePaths(func(path string, node FSNode) error {
+			if path == alt.Link {
+				ec.Addf("%s is invalid: link \"%s\" is also an entry in this package", entryDesc, path)
+				isValid = false
+			}
+			return nil
+		})
+	}
+
+	return alt, isValid
+}
+
+func parseConffileMigration(data ConffileMigrationSection, pkg *Package, ec *ErrorCollector, entryIdx int) (migration ConffileMigration, isValid bool) {
+	migration = ConffileMigration{
+		From:  data.From,
+		To:    data.To,
+		Since: strings.TrimSpace(data.Since),
+	}
+	if migration.Since == "" {
+		migration.Since = pkg.Version
+	}
+
+	if migration.From == "" {
+		ec.Addf("conffileMigration %d is invalid: missing \"from\" attribute", entryIdx)
+		return migration, false
+	}
+	entryDesc := fmt.Sprintf("conffileMigration \"%s\"", migration.From)
+	isValid = true
+
+	//both paths end up in maintainer scripts
+	for _, field := range []struct{ Key, Value string }{{"from", migration.From}, {"to", migration.To}} {
+		if field.Value != "" && !scriptablePathRx.MatchString(field.Value) {
+			ec.Addf("%s is invalid: \"%s\" must be an absolute path without trailing slash, consisting only of letters, digits and the characters \"_.+@:-\"", entryDesc, field.Key)
+			isValid = false
+		}
+	}
+	//(if the package version is invalid, it has already been complained about)
+	if migration.Since != "" && !versionRx.MatchString(migration.Since) {
+		ec.Addf("%s is invalid: \"since\" must be a version like \"1.2.0\", found \"%s\"", entryDesc, migration.Since)
+		isValid = false
+	}
+	if !isValid {
+		return migration, false
+	}
+
+	//the old path must be gone from the package, and the new path must be a
+	//file in the package
+	var fromFound, toFound bool
+	pkg.WalkFSWithAbsolutePaths(func(path string, node FSNode) error {
+		if path == migration.From {
+			fromFound = true
+		}
+		if _, isFile := node.(*FSRegularFile); isFile && path == migration.To {
+			toFound = true
+		}
+		return nil
+	})
+	if fromFound {
+		ec.Addf("%s is invalid: \"%s\" is still an entry in this package", entryDesc, migration.From)
+		isValid = false
+	}
+	if migration.To != "" && !toFound {
+		ec.Addf("%s is invalid: \"%s\" is not a file in this package", entryDesc, migration.To)
+		isValid = false
+	}
+
+	return migration, isValid
+}
+
 //path is the path to be validated.
 //entryType and entryIdx are used for error messages and describe the entry.
 func validatePath(path string, ec *ErrorCollector, entryType string, entryIdx int) bool {
@@ -391,30 +901,36 @@ func parseFileMode(modeStr string, defaultMode os.FileMode, ec *ErrorCollector,
 	return os.FileMode(value)
 }
 
-func parseFileContent(content string, contentFrom string, dontPruneIndent bool, baseDirectory string, ec *ErrorCollector, entryDesc string) string {
+//parseFileContent returns a FSRegularFile (without metadata) holding the
+//content of a file section. Files referenced by `contentFrom` are not read
+//into memory; they are read again from disk when the package is built.
+func parseFileContent(content string, contentFrom string, dontPruneIndent bool, baseDirectory string, ec *ErrorCollector, entryDesc string) *FSRegularFile {
 	//option 1: content given verbatim in "content" field
 	if content != "" {
 		if contentFrom != "" {
 			ec.Addf("%s is invalid: cannot use both `content` and `contentFrom`", entryDesc)
 		}
 		if dontPruneIndent {
-			return content
+			return &FSRegularFile{Content: content}
 		}
-		return string(pruneIndentation([]byte(content)))
+		return &FSRegularFile{Content: string(pruneIndentation([]byte(content)))}
 	}
 
 	//option 2: content referenced in "contentFrom" field
 	if contentFrom == "" {
 		ec.Addf("%s is invalid: missing content", entryDesc)
-		return ""
+		return &FSRegularFile{}
 	}
 	if !strings.HasPrefix(contentFrom, "/") {
 		//resolve relative paths
 		contentFrom = filepath.Join(baseDirectory, contentFrom)
 	}
-	bytes, err := ioutil.ReadFile(contentFrom)
-	ec.Add(err)
-	return string(bytes)
+	node, err := NewFSRegularFileFromPath(contentFrom)
+	if err != nil {
+		ec.Add(err)
+		return &FSRegularFile{}
+	}
+	return node
 }
 
 func pruneIndentation(text []byte) []byte {
@@ -0,0 +1,218 @@
+/*******************************************************************************
+*
+* Copyright 2018 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package common
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+//The following types describe an in-toto statement (see
+//<https://github.com/in-toto/attestation/blob/main/spec/v1/statement.md>)
+//with a SLSA provenance predicate (see <https://slsa.dev/provenance/v1>).
+
+type inTotoStatement struct {
+	Type          string                     `json:"_type"`
+	Subject       []inTotoResourceDescriptor `json:"subject"`
+	PredicateType string                     `json:"predicateType"`
+	Predicate     slsaProvenance             `json:"predicate"`
+}
+
+type inTotoResourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaProvenance struct {
+	BuildDefinition slsaBuildDefinition `json:"buildDefinition"`
+	RunDetails      slsaRunDetails      `json:"runDetails"`
+}
+
+type slsaBuildDefinition struct {
+	BuildType            string                     `json:"buildType"`
+	ExternalParameters   map[string]interface{}     `json:"externalParameters"`
+	ResolvedDependencies []inTotoResourceDescriptor `json:"resolvedDependencies"`
+}
+
+type slsaRunDetails struct {
+	Builder slsaBuilder `json:"builder"`
+}
+
+type slsaBuilder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version"`
+}
+
+//dsseEnvelope is a signed envelope as per
+//<https://github.com/secure-systems-lab/dsse/blob/master/envelope.md>.
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+const inTotoPayloadType = "application/vnd.in-toto+json"
+
+//sha256Digest returns the hex-encoded SHA-256 digest of the given data.
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+//ProvenanceOptions contains everything about the build that goes into a
+//provenance statement, but is not contained in the Package.
+type ProvenanceOptions struct {
+	//Format is the name of the package format, e.g. "debian".
+	Format string
+	//Arguments contains the command-line arguments of holo-build.
+	Arguments []string
+	//InputFileName is the name of the package definition file, or "" if it
+	//was read from standard input.
+	InputFileName string
+	//SigningKey is used to sign the statement, if not nil.
+	SigningKey ed25519.PrivateKey
+}
+
+//MakeProvenance returns an in-toto statement with a SLSA provenance predicate
+//for the package file with the given name and SHA-256 digest. The statement is
+//reproducible since it does not contain timestamps. If a signing key is given,
+//the statement is wrapped in a signed DSSE envelope.
+func (pkg *Package) MakeProvenance(pkgFile string, pkgDigest string, opts ProvenanceOptions) ([]byte, error) {
+	definitionName := opts.InputFileName
+	if definitionName == "" {
+		definitionName = "-"
+	}
+	dependencies := []inTotoResourceDescriptor{{
+		Name:   definitionName,
+		Digest: map[string]string{"sha256": pkg.DefinitionDigest},
+	}}
+	for _, file := range pkg.SourceFiles {
+		dependencies = append(dependencies, inTotoResourceDescriptor{
+			Name:   file.Path,
+			Digest: map[string]string{"sha256": file.SHA256},
+		})
+	}
+
+	arguments := opts.Arguments
+	if arguments == nil {
+		arguments = []string{}
+	}
+	statement := inTotoStatement{
+		Type: "https://in-toto.io/Statement/v1",
+		Subject: []inTotoResourceDescriptor{{
+			Name:   filepath.Base(pkgFile),
+			Digest: map[string]string{"sha256": pkgDigest},
+		}},
+		PredicateType: "https://slsa.dev/provenance/v1",
+		Predicate: slsaProvenance{
+			BuildDefinition: slsaBuildDefinition{
+				BuildType: "https://github.com/holocm/holo-build/provenance/v1",
+				ExternalParameters: map[string]interface{}{
+					"format":    opts.Format,
+					"arguments": arguments,
+				},
+				ResolvedDependencies: dependencies,
+			},
+			RunDetails: slsaRunDetails{
+				Builder: slsaBuilder{
+					ID:      "https://github.com/holocm/holo-build",
+					Version: map[string]string{"holo-build": VersionString()},
+				},
+			},
+		},
+	}
+
+	payload, err := marshalJSON(statement)
+	if err != nil || opts.SigningKey == nil {
+		return payload, err
+	}
+
+	//sign the pre-authentication encoding of the payload (see DSSE protocol)
+	pae := fmt.Sprintf("DSSEv1 %d %s %d ", len(inTotoPayloadType), inTotoPayloadType, len(payload))
+	signature := ed25519.Sign(opts.SigningKey, append([]byte(pae), payload...))
+	return marshalJSON(dsseEnvelope{
+		PayloadType: inTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []dsseSignature{{
+			KeyID: signingKeyID(opts.SigningKey),
+			Sig:   base64.StdEncoding.EncodeToString(signature),
+		}},
+	})
+}
+
+//signingKeyID identifies a signing key by the SHA-256 digest of its public
+//key in PKIX format (which is what `openssl pkey -pubout` prints).
+func signingKeyID(key ed25519.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return ""
+	}
+	return sha256Digest(der)
+}
+
+//ReadSigningKey reads an Ed25519 private key in PKCS #8 format from the given
+//PEM file (as generated by `openssl genpkey -algorithm ed25519`).
+func ReadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s does not contain a PEM-encoded private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key in %s: %s", path, err.Error())
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New(path + " does not contain an Ed25519 private key")
+	}
+	return edKey, nil
+}
+
+//marshalJSON serializes the given value like json.MarshalIndent, but without
+//escaping angle brackets (which would make mail addresses unreadable).
+func marshalJSON(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(value)
+	return buf.Bytes(), err
+}
@@ -0,0 +1,129 @@
+/*******************************************************************************
+*
+* Copyright 2018 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+//RelationMap maps logical package names (as used in the package relations of
+//a package definition) to the actual package names for each package format.
+//The outer key is the logical name, the inner key is the format name (as in
+//the --format option, e.g. "debian").
+type RelationMap map[string]map[string]string
+
+//relationMapFormats contains the format names that may appear in a
+//RelationMap.
+var relationMapFormats = map[string]bool{"debian": true, "pacman": true, "rpm": true}
+
+//ReadRelationMap reads a RelationMap from the given TOML file, which looks
+//like:
+//
+//    [openssh]
+//    debian = "openssh-server"
+//    rpm    = "openssh-server"
+//
+//Formats that are not mentioned for a logical name use the logical name
+//as-is. The returned SourceFile describes the file that was read (for
+//provenance statements).
+func ReadRelationMap(path string) (RelationMap, SourceFile, error) {
+	source := SourceFile{Path: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, source, fmt.Errorf("cannot read relation map %s: %s", path, err.Error())
+	}
+	source.SHA256 = sha256Digest(data)
+	var m RelationMap
+	_, err = toml.Decode(string(data), &m)
+	if err != nil {
+		return nil, source, fmt.Errorf("cannot read relation map %s: %s", path, err.Error())
+	}
+
+	ec := &ErrorCollector{}
+	for _, logicalName := range m.sortedKeys() {
+		for formatName, name := range m[logicalName] {
+			switch {
+			case !relationMapFormats[formatName]:
+				ec.Addf("relation map %s is invalid: unknown package format \"%s\" in entry \"%s\"", path, formatName, logicalName)
+			case strings.TrimSpace(name) == "" || strings.ContainsAny(name, " \t\r\n"):
+				ec.Addf("relation map %s is invalid: \"%s\" is not a package name (found in entry \"%s\")", path, name, logicalName)
+			}
+		}
+	}
+	if len(ec.Errors) > 0 {
+		return nil, source, ec.Errors[0]
+	}
+	return m, source, nil
+}
+
+func (m RelationMap) sortedKeys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//MapRelationNames rewrites the names of all related packages in the given
+//package into the names for the given package format. If strict is true,
+//names that have no mapping for this format are reported as errors.
+//References to package groups must have been resolved already (see
+//ResolvePackageGroups), so that their member packages are mapped as well.
+func (pkg *Package) MapRelationNames(m RelationMap, formatName string, strict bool) []error {
+	ec := &ErrorCollector{}
+	if strict && m == nil {
+		ec.Addf("strict relation mapping was requested, but no relation map was given")
+		return ec.Errors
+	}
+
+	mapRelationNames(m, formatName, strict, "requires", pkg.Requires, ec)
+	mapRelationNames(m, formatName, strict, "predepends", pkg.PreDepends, ec)
+	mapRelationNames(m, formatName, strict, "provides", pkg.Provides, ec)
+	mapRelationNames(m, formatName, strict, "conflicts", pkg.Conflicts, ec)
+	mapRelationNames(m, formatName, strict, "breaks", pkg.Breaks, ec)
+	mapRelationNames(m, formatName, strict, "replaces", pkg.Replaces, ec)
+	return ec.Errors
+}
+
+func mapRelationNames(m RelationMap, formatName string, strict bool, relType string, rels []PackageRelation, ec *ErrorCollector) {
+	for idx := range rels {
+		rel := &rels[idx]
+		if rel.HasAlternatives() {
+			mapRelationNames(m, formatName, strict, relType, rel.Alternatives, ec)
+			continue
+		}
+
+		name := rel.RelatedPackage
+		mappedName, exists := m[name][formatName]
+		switch {
+		case exists:
+			rel.RelatedPackage = mappedName
+		case strict:
+			ec.Addf("Package name \"%s\" has no entry for %s in the relation map (found in %s)", name, formatName, relType)
+		}
+	}
+}
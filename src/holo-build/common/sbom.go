@@ -0,0 +1,313 @@
+/*******************************************************************************
+*
+* Copyright 2018 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package common
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+//The following types describe a subset of the JSON serialization of an SPDX
+//2.3 document (see <https://spdx.github.io/spdx-spec/v2.3/>).
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Files             []spdxFile         `json:"files,omitempty"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID                string                  `json:"SPDXID"`
+	Name                  string                  `json:"name"`
+	VersionInfo           string                  `json:"versionInfo,omitempty"`
+	Supplier              string                  `json:"supplier,omitempty"`
+	DownloadLocation      string                  `json:"downloadLocation"`
+	FilesAnalyzed         bool                    `json:"filesAnalyzed"`
+	VerificationCode      *spdxVerificationCode   `json:"packageVerificationCode,omitempty"`
+	LicenseConcluded      string                  `json:"licenseConcluded,omitempty"`
+	LicenseDeclared       string                  `json:"licenseDeclared,omitempty"`
+	CopyrightText         string                  `json:"copyrightText,omitempty"`
+	Summary               string                  `json:"summary,omitempty"`
+	Comment               string                  `json:"comment,omitempty"`
+	ExternalRefs          []spdxExternalReference `json:"externalRefs,omitempty"`
+	PrimaryPackagePurpose string                  `json:"primaryPackagePurpose,omitempty"`
+}
+
+type spdxVerificationCode struct {
+	Value string `json:"packageVerificationCodeValue"`
+}
+
+type spdxExternalReference struct {
+	Category string `json:"referenceCategory"`
+	Type     string `json:"referenceType"`
+	Locator  string `json:"referenceLocator"`
+}
+
+type spdxFile struct {
+	SPDXID           string         `json:"SPDXID"`
+	FileName         string         `json:"fileName"`
+	Checksums        []spdxChecksum `json:"checksums"`
+	LicenseConcluded string         `json:"licenseConcluded"`
+	CopyrightText    string         `json:"copyrightText"`
+}
+
+type spdxChecksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"checksumValue"`
+}
+
+type spdxRelationship struct {
+	Element        string `json:"spdxElementId"`
+	Type           string `json:"relationshipType"`
+	RelatedElement string `json:"relatedSpdxElement"`
+	Comment        string `json:"comment,omitempty"`
+}
+
+//purlTypes contains the package URL type (see
+//<https://github.com/package-url/purl-spec>) for each package format.
+var purlTypes = map[string]string{
+	"debian": "deb",
+	"pacman": "alpm",
+	"rpm":    "rpm",
+}
+
+//SBOMPath returns the path where EmbedSBOM places the SBOM in the package.
+func (pkg *Package) SBOMPath() string {
+	return "/usr/share/doc/" + pkg.Name + "/sbom.spdx.json"
+}
+
+//EmbedSBOM adds a software bill of materials (see MakeSBOM) to the package
+//at SBOMPath(). Since this needs to happen before the package is built, the
+//SBOM only covers the files from the package definition, but not those that
+//the generator adds during Build() (e.g. the license file).
+func (pkg *Package) EmbedSBOM(generator Generator, formatName string) error {
+	sbom, err := pkg.MakeSBOM(generator, formatName)
+	if err != nil {
+		return err
+	}
+	ec := &ErrorCollector{}
+	pkg.InsertFSNode(&FSRegularFile{
+		Content:  string(sbom),
+		Metadata: FSNodeMetadata{Mode: 0644},
+	}, pkg.SBOMPath(), ec)
+	if len(ec.Errors) > 0 {
+		return fmt.Errorf("cannot embed SBOM at %s: %s", pkg.SBOMPath(), ec.Errors[0].Error())
+	}
+	return nil
+}
+
+//MakeSBOM returns a software bill of materials for this package in the JSON
+//serialization of SPDX 2.3. It lists all regular files in the package with
+//their checksums, as well as the package's relations and license.
+//
+//Like the packages themselves, the SBOM is reproducible: It does not contain
+//a timestamp (the creation time is always set to the Unix epoch, like the
+//mtimes of all files in the package), and the document namespace is derived
+//from the SBOM's contents.
+func (pkg *Package) MakeSBOM(generator Generator, formatName string) ([]byte, error) {
+	fullVersion := pkg.Version
+	if cmp, ok := generator.(VersionComparer); ok {
+		fullVersion = cmp.FullVersionString(pkg)
+	}
+
+	doc := spdxDocument{
+		SPDXVersion: "SPDX-2.3",
+		DataLicense: "CC0-1.0",
+		SPDXID:      "SPDXRef-DOCUMENT",
+		Name:        pkg.Name + "-" + fullVersion,
+	}
+
+	//describe the package itself
+	mainPkg := spdxPackage{
+		SPDXID:                "SPDXRef-Package",
+		Name:                  pkg.Name,
+		VersionInfo:           fullVersion,
+		DownloadLocation:      "NOASSERTION",
+		FilesAnalyzed:         true,
+		LicenseConcluded:      "NOASSERTION",
+		LicenseDeclared:       "NOASSERTION",
+		CopyrightText:         "NOASSERTION",
+		Summary:               pkg.Description,
+		PrimaryPackagePurpose: "INSTALL",
+	}
+	if pkg.Author != "" {
+		mainPkg.Supplier = "Person: " + pkg.Author
+	}
+	if pkg.License != "" {
+		mainPkg.LicenseDeclared = pkg.License
+	}
+	if purlType := purlTypes[formatName]; purlType != "" {
+		mainPkg.ExternalRefs = []spdxExternalReference{{
+			Category: "PACKAGE-MANAGER",
+			Type:     "purl",
+			Locator: fmt.Sprintf("pkg:%s/%s@%s?arch=%s",
+				purlType, url.PathEscape(pkg.Name), url.PathEscape(fullVersion),
+				url.QueryEscape(generator.ArchitectureName(pkg)),
+			),
+		}}
+	}
+	doc.Relationships = append(doc.Relationships, spdxRelationship{
+		Element:        "SPDXRef-DOCUMENT",
+		Type:           "DESCRIBES",
+		RelatedElement: mainPkg.SPDXID,
+	})
+
+	//list all regular files (except for metadata files that the generator
+	//has placed among them, like .PKGINFO for pacman)
+	isMetadataFile := make(map[string]bool)
+	if lister, ok := generator.(MetadataFileLister); ok {
+		for _, path := range lister.MetadataFiles() {
+			isMetadataFile[path] = true
+		}
+	}
+	var sha1s []string
+	pkg.WalkFSWithRelativePaths(func(path string, node FSNode) error {
+		file, ok := node.(*FSRegularFile)
+		if !ok || isMetadataFile[path] {
+			return nil
+		}
+		sha1sum := file.SHA1Digest()
+		sha1s = append(sha1s, sha1sum)
+		spdxID := fmt.Sprintf("SPDXRef-File-%d", len(doc.Files)+1)
+		doc.Files = append(doc.Files, spdxFile{
+			SPDXID:   spdxID,
+			FileName: "./" + path,
+			Checksums: []spdxChecksum{
+				{Algorithm: "SHA1", Value: sha1sum},
+				{Algorithm: "SHA256", Value: file.SHA256Digest()},
+			},
+			LicenseConcluded: "NOASSERTION",
+			CopyrightText:    "NOASSERTION",
+		})
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			Element:        mainPkg.SPDXID,
+			Type:           "CONTAINS",
+			RelatedElement: spdxID,
+		})
+		return nil
+	})
+	if len(doc.Files) == 0 {
+		mainPkg.FilesAnalyzed = false
+	} else {
+		//see SPDX 2.3, section 7.9
+		sort.Strings(sha1s)
+		code := sha1.Sum([]byte(strings.Join(sha1s, "")))
+		mainPkg.VerificationCode = &spdxVerificationCode{Value: hex.EncodeToString(code[:])}
+	}
+	doc.Packages = append(doc.Packages, mainPkg)
+
+	//list related packages (SPDX has no relationship types for conflicts
+	//etc., so these use "OTHER" with a comment)
+	relationTypes := []struct {
+		Relations []PackageRelation
+		Type      string
+		Comment   string
+	}{
+		{pkg.Requires, "DEPENDS_ON", ""},
+		{pkg.PreDepends, "HAS_PREREQUISITE", ""},
+		{pkg.Provides, "OTHER", "provides"},
+		{pkg.Conflicts, "OTHER", "conflicts"},
+		{pkg.Breaks, "OTHER", "breaks"},
+		{pkg.Replaces, "OTHER", "replaces"},
+	}
+	for _, relType := range relationTypes {
+		for _, rel := range relType.Relations {
+			spdxID := fmt.Sprintf("SPDXRef-Related-%d", len(doc.Packages))
+			doc.Packages = append(doc.Packages, spdxPackage{
+				SPDXID:           spdxID,
+				Name:             relatedPackageNames(rel),
+				DownloadLocation: "NOASSERTION",
+				FilesAnalyzed:    false,
+				Comment:          describeVersionConstraints(rel),
+			})
+			comment := relType.Comment
+			if rel.Origin != "" {
+				comment = strings.TrimPrefix(comment+", "+rel.Origin, ", ")
+			}
+			doc.Relationships = append(doc.Relationships, spdxRelationship{
+				Element:        mainPkg.SPDXID,
+				Type:           relType.Type,
+				RelatedElement: spdxID,
+				Comment:        comment,
+			})
+		}
+	}
+
+	//derive the document namespace from the contents of the document (but not
+	//from the creation info, so that it does not depend on the holo-build
+	//version)
+	contents, err := marshalJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(contents)
+	doc.DocumentNamespace = fmt.Sprintf("https://spdx.org/spdxdocs/holo-build/%s-%s", doc.Name, hex.EncodeToString(digest[:16]))
+	doc.CreationInfo = spdxCreationInfo{
+		Created:  "1970-01-01T00:00:00Z",
+		Creators: []string{"Tool: holo-build-" + VersionString()},
+	}
+	return marshalJSON(doc)
+}
+
+//relatedPackageNames returns the name of the related package, or the names
+//of all alternatives (like "foo | bar").
+func relatedPackageNames(rel PackageRelation) string {
+	if !rel.HasAlternatives() {
+		return rel.RelatedPackage
+	}
+	names := make([]string, 0, len(rel.Alternatives))
+	for _, alt := range rel.Alternatives {
+		names = append(names, alt.RelatedPackage)
+	}
+	return strings.Join(names, " | ")
+}
+
+//describeVersionConstraints returns the version constraints of the relation
+//(like "foo >= 1.0, foo < 2.0"), or "" if there are none.
+func describeVersionConstraints(rel PackageRelation) string {
+	alternatives := rel.Alternatives
+	if !rel.HasAlternatives() {
+		alternatives = []PackageRelation{rel}
+	}
+	var terms []string
+	for _, alt := range alternatives {
+		for _, c := range alt.Constraints {
+			terms = append(terms, fmt.Sprintf("%s %s %s", alt.RelatedPackage, c.Relation, c.Version))
+		}
+	}
+	return strings.Join(terms, ", ")
+}
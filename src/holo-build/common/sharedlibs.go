@@ -0,0 +1,132 @@
+/*******************************************************************************
+*
+* Copyright 2018 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package common
+
+import "debug/elf"
+
+//sharedLibrary is a shared library that is needed or provided by an ELF
+//binary in the package.
+type sharedLibrary struct {
+	SoName  string
+	Is64Bit bool
+	Path    string //the first binary that needs or provides it
+}
+
+//NativeName returns the name of the shared library as a capability for the
+//given package format, or "" if the package format does not have relations
+//to shared libraries.
+func (lib sharedLibrary) NativeName(formatName string) string {
+	if formatName != "rpm" {
+		return ""
+	}
+	//same spelling as elfdeps(1) from rpm
+	if lib.Is64Bit {
+		return lib.SoName + "()(64bit)"
+	}
+	return lib.SoName
+}
+
+//findSharedLibraries returns the shared libraries needed (DT_NEEDED) and
+//provided (DT_SONAME) by the ELF binaries in this package. Libraries needed by
+//one binary and provided by another are not included in the first list.
+func (pkg *Package) findSharedLibraries() (needed, provided []sharedLibrary) {
+	neededBySoName := make(map[sharedLibrary]bool)
+	providedBySoName := make(map[sharedLibrary]bool)
+
+	for _, binary := range pkg.findELFBinaries() {
+		is64Bit := binary.Class == elf.ELFCLASS64
+		for _, soName := range binary.SoNames {
+			lib := sharedLibrary{SoName: soName, Is64Bit: is64Bit}
+			if !providedBySoName[lib] {
+				providedBySoName[lib] = true
+				lib.Path = binary.Path
+				provided = append(provided, lib)
+			}
+		}
+		for _, soName := range binary.Needed {
+			lib := sharedLibrary{SoName: soName, Is64Bit: is64Bit}
+			if !neededBySoName[lib] {
+				neededBySoName[lib] = true
+				lib.Path = binary.Path
+				needed = append(needed, lib)
+			}
+		}
+	}
+
+	//remove libraries that are provided by this package itself
+	filtered := needed[:0]
+	for _, lib := range needed {
+		if !providedBySoName[sharedLibrary{SoName: lib.SoName, Is64Bit: lib.Is64Bit}] {
+			filtered = append(filtered, lib)
+		}
+	}
+	return filtered, provided
+}
+
+//AddSharedLibraryRelations adds requirements on the shared libraries needed
+//by the ELF binaries in this package, and (if the package format supports
+//it) provides the shared libraries contained in this package. This does
+//nothing unless the package definition contains `autoSharedLibraries = true`.
+//
+//For RPM, the relations use the native spelling of shared libraries (e.g.
+//"libfoo.so.1()(64bit)"). Other package formats cannot refer to shared
+//libraries directly, so the requirements are looked up in the package's
+//SharedLibraryMap, and libraries without an entry are reported as errors.
+func (pkg *Package) AddSharedLibraryRelations(formatName string) []error {
+	if !pkg.AutoSharedLibraries {
+		return nil
+	}
+	ec := &ErrorCollector{}
+	needed, provided := pkg.findSharedLibraries()
+
+	for _, lib := range needed {
+		name := lib.NativeName(formatName)
+		if name == "" {
+			var exists bool
+			name, exists = pkg.SharedLibraryMap[lib.SoName][formatName]
+			if !exists {
+				ec.Addf("Shared library \"%s\" has no entry for %s in the shared library map (needed by %s)", lib.SoName, formatName, lib.Path)
+				continue
+			}
+		}
+		pkg.Requires = appendGeneratedRelation(pkg.Requires, name, "needed by "+lib.Path)
+	}
+
+	for _, lib := range provided {
+		if name := lib.NativeName(formatName); name != "" {
+			pkg.Provides = appendGeneratedRelation(pkg.Provides, name, "provided by "+lib.Path)
+		}
+	}
+
+	return ec.Errors
+}
+
+//appendGeneratedRelation appends a relation to the given package with the
+//given origin (see PackageRelation.Origin) to the list, unless the list
+//already contains a relation to this package.
+func appendGeneratedRelation(rels []PackageRelation, name, origin string) []PackageRelation {
+	for _, rel := range rels {
+		if rel.RelatedPackage == name {
+			return rels
+		}
+	}
+	return append(rels, PackageRelation{RelatedPackage: name, Origin: "generated, " + origin})
+}
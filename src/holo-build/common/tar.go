@@ -22,25 +22,21 @@ package common
 
 import (
 	"archive/tar"
-	"bytes"
-	"compress/gzip"
-	"os"
-	"os/exec"
+	"io"
 	"strings"
 	"time"
 )
 
-//ToTarArchive creates a TAR archive containing this directory and all the
-//filesystem entries in it.
+//WriteTarArchive writes a TAR archive containing this directory and all the
+//filesystem entries in it into the given writer.
 //
 //With `leadingDot = true`, generate entry paths like `./foo/bar.conf`.
 //WIth `leadingDot = false`, generate entry paths like `foo/bar.conf`.
 //
 //With `skipRootDirectory = true`, don't generate an entry for the root
 //directory in the resulting package.
-func (d *FSDirectory) ToTarArchive(leadingDot, skipRootDirectory bool) ([]byte, error) {
-	var buf bytes.Buffer
-	tw := tar.NewWriter(&buf)
+func (d *FSDirectory) WriteTarArchive(w io.Writer, leadingDot, skipRootDirectory bool) error {
+	tw := tar.NewWriter(w)
 
 	timestamp := time.Unix(0, 0)
 
@@ -68,7 +64,7 @@ func (d *FSDirectory) ToTarArchive(leadingDot, skipRootDirectory bool) ([]byte,
 		case *FSRegularFile:
 			err = tw.WriteHeader(&tar.Header{
 				Name:       path,
-				Size:       int64(len([]byte(n.Content))),
+				Size:       n.Size(),
 				Typeflag:   tar.TypeReg,
 				Mode:       int64(n.FileModeForArchive(false)),
 				Uid:        int(n.Metadata.UID()),
@@ -77,6 +73,9 @@ func (d *FSDirectory) ToTarArchive(leadingDot, skipRootDirectory bool) ([]byte,
 				AccessTime: timestamp,
 				ChangeTime: timestamp,
 			})
+		case *FSGhostFile:
+			//not shipped in the package
+			return nil
 		case *FSSymlink:
 			err = tw.WriteHeader(&tar.Header{
 				Name:       path,
@@ -94,48 +93,26 @@ func (d *FSDirectory) ToTarArchive(leadingDot, skipRootDirectory bool) ([]byte,
 			return err
 		}
 		if n, ok := node.(*FSRegularFile); ok {
-			_, err = tw.Write([]byte(n.Content))
-			return err
+			return n.WriteContentsTo(tw)
 		}
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	err = tw.Close()
-	return buf.Bytes(), err
+	return tw.Close()
 }
 
-//ToTarGZArchive is identical to ToTarArchive, but GZip-compresses the result.
-func (d *FSDirectory) ToTarGZArchive(leadingDot, skipRootDirectory bool) ([]byte, error) {
-	data, err := d.ToTarArchive(leadingDot, skipRootDirectory)
+//WriteCompressedTarArchive is identical to WriteTarArchive, but compresses the
+//result with the given compression format.
+func (d *FSDirectory) WriteCompressedTarArchive(w io.Writer, c Compression, opts CompressionOptions, leadingDot, skipRootDirectory bool) error {
+	cw, err := c.NewWriter(w, -1, opts)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	var buf bytes.Buffer
-	w := gzip.NewWriter(&buf)
-
-	_, err = w.Write(data)
+	err = d.WriteTarArchive(cw, leadingDot, skipRootDirectory)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	err = w.Close()
-	return buf.Bytes(), err
-}
-
-//ToTarXZArchive is identical to ToTarArchive, but GZip-compresses the result.
-func (d *FSDirectory) ToTarXZArchive(leadingDot, skipRootDirectory bool) ([]byte, error) {
-	data, err := d.ToTarArchive(leadingDot, skipRootDirectory)
-	if err != nil {
-		return nil, err
-	}
-
-	//since we don't have a "compress/xz" package, use the "xz" binary instead
-	cmd := exec.Command("xz", "--compress")
-	cmd.Stdin = bytes.NewReader(data)
-	cmd.Stderr = os.Stderr
-	return cmd.Output()
+	return cw.Close()
 }
@@ -22,6 +22,7 @@ package common
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 )
 
@@ -35,3 +36,19 @@ func ShowWarning(msg string) {
 func WarnDeprecatedKey(key string) {
 	ShowWarning("The '" + key + "' key is deprecated. See `man 1 holo-build` for details.")
 }
+
+//NewSpoolFile creates an anonymous temporary file for intermediate build
+//results that are too large to be held in memory. The file is unlinked right
+//away, so it disappears as soon as it is closed.
+func NewSpoolFile() (*os.File, error) {
+	f, err := ioutil.TempFile("", "holo-build-")
+	if err != nil {
+		return nil, err
+	}
+	err = os.Remove(f.Name())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
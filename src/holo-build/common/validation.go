@@ -20,7 +20,11 @@
 
 package common
 
-import "regexp"
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
 
 //RegexSet is a collection of regular expressions for validating a package.
 //A RegexSet is typically constructed by a common.Generator for calling
@@ -66,14 +70,17 @@ func (pkg *Package) ValidateWith(r RegexSet, archMap map[Architecture]string) []
 		ec.Addf("Package version \"%s\" is not acceptable for %s packages", pkg.Version, cr.FormatName)
 	}
 
-	//check if architecture is supported by this generator
-	if _, ok := archMap[pkg.Architecture]; !ok {
+	//check if architecture is supported by this generator (raw architecture
+	//strings are the user's responsibility)
+	if _, ok := archMap[pkg.Architecture]; !ok && pkg.Architecture != ArchitectureRaw {
 		ec.Addf("Architecture \"%s\" is not acceptable for %s packages", pkg.ArchitectureInput, cr.FormatName)
 	}
 
 	validatePackageRelations(cr, "requires", pkg.Requires, &ec)
+	validatePackageRelations(cr, "predepends", pkg.PreDepends, &ec)
 	validatePackageRelations(cr, "provides", pkg.Provides, &ec)
 	validatePackageRelations(cr, "conflicts", pkg.Conflicts, &ec)
+	validatePackageRelations(cr, "breaks", pkg.Breaks, &ec)
 	validatePackageRelations(cr, "replaces", pkg.Replaces, &ec)
 
 	return ec.Errors
@@ -81,15 +88,39 @@ func (pkg *Package) ValidateWith(r RegexSet, archMap map[Architecture]string) []
 
 func validatePackageRelations(r *compiledRegexSet, relType string, rels []PackageRelation, ec *ErrorCollector) {
 	for _, rel := range rels {
+		if rel.HasAlternatives() {
+			for _, alt := range rel.Alternatives {
+				if alt.RelatedPackage != stripGroupSyntax(alt.RelatedPackage) {
+					ec.Addf("Package groups and exclusions cannot be used in alternatives (found \"%s\" in %s)", alt.RelatedPackage, relType)
+				}
+			}
+			validatePackageRelations(r, relType, rel.Alternatives, ec)
+			continue
+		}
+		//references to package groups and exclusions ("group:foo",
+		//"except:foo", "except:group:foo") have already been resolved
 		if !r.RelatedName.MatchString(rel.RelatedPackage) {
-			ec.Addf("Package name \"%s\" is not acceptable for %s packages (found in %s)", rel.RelatedPackage, r.FormatName, relType)
+			ec.Addf("Package name \"%s\" is not acceptable for %s packages (found in %s)", rel.RelatedPackage, r.FormatName, describeRelation(rel, relType))
 		}
 		for _, constraint := range rel.Constraints {
 			if !r.RelatedVersion.MatchString(constraint.Version) {
 				ec.Addf("Version in \"%s %s %s\" is not acceptable for %s packages (found in %s)",
-					rel.RelatedPackage, constraint.Relation, constraint.Version, r.FormatName, relType,
+					rel.RelatedPackage, constraint.Relation, constraint.Version, r.FormatName, describeRelation(rel, relType),
 				)
 			}
 		}
 	}
 }
+
+//describeRelation describes where a relation comes from, for use in error
+//messages like "... (found in requires)".
+func describeRelation(rel PackageRelation, relType string) string {
+	if rel.Origin == "" {
+		return relType
+	}
+	return fmt.Sprintf("%s, %s", relType, rel.Origin)
+}
+
+func stripGroupSyntax(name string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(name, "except:"), "group:")
+}
@@ -21,10 +21,14 @@
 package debian
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"time"
 
 	"github.com/holocm/holo-build/src/holo-build/common"
 )
@@ -38,15 +42,25 @@ var archMap = map[common.Architecture]string{
 	common.ArchitectureX86_64: "amd64",
 	common.ArchitectureARMv5:  "armel",
 	// common.ArchitectureARMv6h is not supported by Debian
-	common.ArchitectureARMv7h:  "armhf",
-	common.ArchitectureAArch64: "arm64",
+	common.ArchitectureARMv7h:   "armhf",
+	common.ArchitectureAArch64:  "arm64",
+	common.ArchitecturePPC64LE:  "ppc64el",
+	common.ArchitectureS390X:    "s390x",
+	common.ArchitectureRISCV64:  "riscv64",
+	common.ArchitectureMIPS64EL: "mips64el",
+	common.ArchitectureLoong64:  "loong64",
+}
+
+//ArchitectureName implements the common.Generator interface.
+func (g *Generator) ArchitectureName(pkg *common.Package) string {
+	return pkg.ArchitectureName(archMap)
 }
 
 //RecommendedFileName implements the common.Generator interface.
 func (g *Generator) RecommendedFileName(pkg *common.Package) string {
 	//this is called after Build(), so we can assume that package name,
 	//version, etc. were already validated
-	return fmt.Sprintf("%s_%s_%s.deb", pkg.Name, fullVersionString(pkg), archMap[pkg.Architecture])
+	return fmt.Sprintf("%s_%s_%s.deb", pkg.Name, fullVersionString(pkg), pkg.ArchitectureName(archMap))
 }
 
 //Validate implements the common.Generator interface.
@@ -61,6 +75,13 @@ func (g *Generator) Validate(pkg *common.Package) []error {
 		RelatedVersion: "(?:[0-9]+:)?" + versionRx + "(?:-[1-9][0-9]*)?", //incl. release/epoch
 		FormatName:     "Debian",
 	}, archMap)
+	errs = append(errs, pkg.ValidateCompression("Debian",
+		common.CompressionNone, common.CompressionGzip, common.CompressionXZ, common.CompressionZstd)...)
+	controlCompression, dataCompression := compressions(pkg)
+	errs = append(errs, pkg.ValidateCompressionLevel(controlCompression)...)
+	if dataCompression != controlCompression {
+		errs = append(errs, pkg.ValidateCompressionLevel(dataCompression)...)
+	}
 
 	if pkg.Author == "" {
 		err := errors.New("The \"package.author\" field is required for Debian packages")
@@ -87,65 +108,257 @@ func fullVersionString(pkg *common.Package) string {
 
 type arArchiveEntry struct {
 	Name string
-	Data []byte
+	Size int64
+	Data io.Reader
 }
 
 //Build implements the common.Generator interface.
-func (g *Generator) Build(pkg *common.Package) ([]byte, error) {
-	//compress data.tar.xz
-	dataTar, err := pkg.FSRoot.ToTarXZArchive(true, false)
+func (g *Generator) Build(pkg *common.Package, w io.Writer) error {
+	appendActions(pkg)
+
+	//Debian does not use /usr/share/licenses; the license (and license text)
+	//goes into the copyright file instead
+	if copyright := pkg.CopyrightFile(); copyright != "" {
+		ec := &common.ErrorCollector{}
+		pkg.InsertFSNode(&common.FSRegularFile{
+			Content:  copyright,
+			Metadata: common.FSNodeMetadata{Mode: 0644},
+		}, "/usr/share/doc/"+pkg.Name+"/copyright", ec)
+		if len(ec.Errors) > 0 {
+			return ec.Errors[0]
+		}
+	}
+
+	//the changelog goes into the documentation directory
+	err := writeChangelog(pkg)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	//prepare a directory into which to assemble the metadata files for control.tar.gz
-	controlTar, err := buildControlTar(pkg)
+	//compress data.tar into a temporary file, since its size needs to be known
+	//before it can be written into the ar archive
+	controlCompression, dataCompression := compressions(pkg)
+	dataTar, err := common.NewSpoolFile()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer dataTar.Close()
+	dataTarBuffer := bufio.NewWriter(dataTar)
+	err = pkg.FSRoot.WriteCompressedTarArchive(dataTarBuffer, dataCompression, pkg.CompressionOptions, true, false)
+	if err != nil {
+		return err
+	}
+	err = dataTarBuffer.Flush()
+	if err != nil {
+		return err
+	}
+	dataTarSize, err := dataTar.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = dataTar.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	//prepare a directory into which to assemble the metadata files for control.tar
+	controlTar, err := buildControlTar(pkg, controlCompression)
+	if err != nil {
+		return err
 	}
 
 	//build ar archive
-	return buildArArchive([]arArchiveEntry{
-		arArchiveEntry{"debian-binary", []byte("2.0\n")},
-		arArchiveEntry{"control.tar.gz", controlTar},
-		arArchiveEntry{"data.tar.xz", dataTar},
+	return writeArArchive(w, []arArchiveEntry{
+		arArchiveEntry{"debian-binary", 4, strings.NewReader("2.0\n")},
+		arArchiveEntry{"control.tar" + controlCompression.FileExtension(), int64(len(controlTar)), bytes.NewReader(controlTar)},
+		arArchiveEntry{"data.tar" + dataCompression.FileExtension(), dataTarSize, dataTar},
 	})
 }
 
-func buildControlTar(pkg *common.Package) ([]byte, error) {
+//appendActions adds the actions that this generator needs in addition to
+//the ones from the package definition.
+func appendActions(pkg *common.Package) {
+	//register alternatives with update-alternatives(1) in the maintainer
+	//scripts, and delete ghost files on purge
+	pkg.AppendActions(pkg.AlternativeActions()...)
+	pkg.AppendActions(pkg.GhostFileCleanupActions()...)
+}
+
+//MaintainerScripts implements the common.Generator interface.
+func (g *Generator) MaintainerScripts(pkg *common.Package) map[string]string {
+	p := pkg.WithBuildActions()
+	appendActions(p)
+	return maintainerScripts(p)
+}
+
+//compressions returns the compression formats for control.tar and data.tar.
+//When no compression was requested, use the combination that all dpkg
+//versions since Debian squeeze understand.
+func compressions(pkg *common.Package) (control, data common.Compression) {
+	if pkg.Compression == common.CompressionDefault {
+		return common.CompressionGzip, common.CompressionXZ
+	}
+	return pkg.Compression, pkg.Compression
+}
+
+func buildControlTar(pkg *common.Package, c common.Compression) ([]byte, error) {
 	//prepare a directory into which to put all these files
 	controlDir := common.NewFSDirectory()
 
-	//place all the required files in there (NOTE: using the conffiles file
-	//does not seem to be appropriate for our use-case, although I'll let more
-	//experienced Debian users judge this one)
+	//place all the required files in there
 	err := writeControlFile(pkg, controlDir)
 	if err != nil {
 		return nil, err
 	}
 	writeMD5SumsFile(pkg, controlDir)
+	writeConffilesFile(pkg, controlDir)
 
-	//write postinst script if necessary
-	script := pkg.Script(common.SetupAction)
-	if script != "" {
-		script := "#!/bin/bash\n" + script + "\n"
-		controlDir.Entries["postinst"] = &common.FSRegularFile{
+	//write maintainer scripts if necessary
+	for name, script := range maintainerScripts(pkg) {
+		controlDir.Entries[name] = &common.FSRegularFile{
 			Content:  script,
 			Metadata: common.FSNodeMetadata{Mode: 0755},
 		}
 	}
 
-	//write postrm script if necessary
-	script = pkg.Script(common.CleanupAction)
-	if script != "" {
-		script := "#!/bin/bash\n" + script + "\n"
-		controlDir.Entries["postrm"] = &common.FSRegularFile{
-			Content:  script,
-			Metadata: common.FSNodeMetadata{Mode: 0755},
+	var buf bytes.Buffer
+	err = controlDir.WriteCompressedTarArchive(&buf, c, pkg.CompressionOptions, true, false)
+	return buf.Bytes(), err
+}
+
+//writeChangelog places the package's changelog in Debian format into the
+//package, compressed like dh_compress does it.
+func writeChangelog(pkg *common.Package) error {
+	if len(pkg.Changelog) == 0 {
+		return nil
+	}
+
+	//reference for this format:
+	//https://www.debian.org/doc/debian-policy/ch-source.html#debian-changelog-debian-changelog
+	var paragraphs []string
+	for _, entry := range pkg.Changelog {
+		text := fmt.Sprintf("%s (%s) unstable; urgency=medium\n\n", pkg.Name, entry.Version)
+		for _, change := range entry.Entries {
+			text += "  * " + strings.Replace(change, "\n", "\n    ", -1) + "\n"
+		}
+		text += fmt.Sprintf("\n -- %s  %s\n", entry.Author, entry.Date.Format(time.RFC1123Z))
+		paragraphs = append(paragraphs, text)
+	}
+
+	//like `gzip -9n`, i.e. without file name and timestamp
+	var buf bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write([]byte(strings.Join(paragraphs, "\n")))
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	ec := &common.ErrorCollector{}
+	pkg.InsertFSNode(&common.FSRegularFile{
+		Content:  buf.String(),
+		Metadata: common.FSNodeMetadata{Mode: 0644},
+	}, "/usr/share/doc/"+pkg.Name+"/changelog.Debian.gz", ec)
+	if len(ec.Errors) > 0 {
+		return ec.Errors[0]
+	}
+	return nil
+}
+
+//maintainerScripts returns the contents of the maintainer scripts for this
+//package, indexed by name.
+func maintainerScripts(pkg *common.Package) map[string]string {
+	scripts := make(map[string]string)
+	add := func(name string, parts ...string) {
+		if script := compileMaintainerScript(parts...); script != "" {
+			scripts[name] = script
+		}
+	}
+
+	//diversions must be in place before our files are unpacked; they are
+	//removed before the cleanup actions run, so that these see the original
+	//files again
+	addDiversions, removeDiversions := compileDiversionScripts(pkg)
+	migrations := compileConffileMigrations(pkg)
+	add("preinst", addDiversions, migrations)
+	add("postinst", migrations, pkg.Script(common.SetupAction))
+	//pre-cleanup actions shall not run on upgrade, only on removal
+	if script := pkg.Script(common.PreCleanupAction); script != "" {
+		add("prerm", "if [ \"$1\" = remove ]; then\n"+script+"\nfi")
+	}
+	purgeScript := pkg.Script(common.PurgeAction)
+	if purgeScript != "" {
+		purgeScript = "if [ \"$1\" = purge ]; then\n" + purgeScript + "\nfi"
+	}
+	add("postrm", removeDiversions, migrations, pkg.Script(common.CleanupAction), purgeScript)
+	return scripts
+}
+
+//compileMaintainerScript returns a maintainer script consisting of all
+//non-empty parts, or "" if all parts are empty.
+func compileMaintainerScript(parts ...string) string {
+	var lines []string
+	for _, part := range parts {
+		if part != "" {
+			lines = append(lines, part)
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "#!/bin/bash\n" + strings.Join(lines, "\n") + "\n"
+}
+
+//compileConffileMigrations returns the script snippet that needs to go into
+//the preinst, postinst and postrm scripts to handle pkg.ConffileMigrations.
+func compileConffileMigrations(pkg *common.Package) string {
+	var lines []string
+	for _, m := range pkg.ConffileMigrations {
+		//the "~" makes the prior-version sort before all releases of the
+		//version that introduced the change (cf. dpkg-maintscript-helper(1))
+		priorVersion := m.Since + "~"
+		if pkg.Epoch > 0 {
+			priorVersion = fmt.Sprintf("%d:%s", pkg.Epoch, priorVersion)
+		}
+		if m.To == "" {
+			lines = append(lines, fmt.Sprintf("dpkg-maintscript-helper rm_conffile %s %s %s -- \"$@\"", m.From, priorVersion, pkg.Name))
+		} else {
+			lines = append(lines, fmt.Sprintf("dpkg-maintscript-helper mv_conffile %s %s %s %s -- \"$@\"", m.From, m.To, priorVersion, pkg.Name))
 		}
 	}
+	return strings.Join(lines, "\n")
+}
+
+//compileDiversionScripts returns the script snippets for the preinst and
+//postrm scripts that divert the files in pkg.Diversions out of the way while
+//this package is installed.
+func compileDiversionScripts(pkg *common.Package) (addScript, removeScript string) {
+	if len(pkg.Diversions) == 0 {
+		return "", ""
+	}
+
+	var addLines, removeLines []string
+	for _, path := range pkg.Diversions {
+		cmd := fmt.Sprintf("dpkg-divert --package %s --rename --divert %s.distrib", pkg.Name, path)
+		addLines = append(addLines, cmd+" --add "+path)
+		removeLines = append(removeLines, cmd+" --remove "+path)
+	}
 
-	return controlDir.ToTarGZArchive(true, false)
+	//NOTE: Adding an existing diversion is a no-op, so we can do this on
+	//every upgrade (which covers upgrades from versions that did not divert
+	//the file yet). Diversions are only removed when the package is removed
+	//for good.
+	addScript = "if [ \"$1\" = install ] || [ \"$1\" = upgrade ]; then\n" +
+		strings.Join(addLines, "\n") + "\nfi"
+	removeScript = "if [ \"$1\" = remove ] || [ \"$1\" = abort-install ] || [ \"$1\" = disappear ]; then\n" +
+		strings.Join(removeLines, "\n") + "\nfi"
+	return addScript, removeScript
 }
 
 func writeControlFile(pkg *common.Package, controlDir *common.FSDirectory) error {
@@ -153,7 +366,7 @@ func writeControlFile(pkg *common.Package, controlDir *common.FSDirectory) error
 	//https://www.debian.org/doc/debian-policy/ch-controlfields.html#s-binarycontrolfiles
 	contents := fmt.Sprintf("Package: %s\n", pkg.Name)
 	contents += fmt.Sprintf("Version: %s\n", fullVersionString(pkg))
-	contents += fmt.Sprintf("Architecture: %s\n", archMap[pkg.Architecture])
+	contents += fmt.Sprintf("Architecture: %s\n", pkg.ArchitectureName(archMap))
 	contents += fmt.Sprintf("Maintainer: %s\n", pkg.Author)
 	contents += fmt.Sprintf("Installed-Size: %d\n", int(pkg.FSRoot.InstalledSizeInBytes()/1024)) // convert bytes to KiB
 	contents += "Section: misc\n"
@@ -166,12 +379,24 @@ func writeControlFile(pkg *common.Package, controlDir *common.FSDirectory) error
 	}
 	contents += rels
 
+	rels, err = compilePackageRelations("Pre-Depends", pkg.PreDepends)
+	if err != nil {
+		return err
+	}
+	contents += rels
+
 	rels, err = compilePackageRelations("Provides", pkg.Provides)
 	if err != nil {
 		return err
 	}
 	contents += rels
 
+	rels, err = compilePackageRelations("Breaks", pkg.Breaks)
+	if err != nil {
+		return err
+	}
+	contents += rels
+
 	rels, err = compilePackageRelations("Conflicts", pkg.Conflicts)
 	if err != nil {
 		return err
@@ -190,6 +415,12 @@ func writeControlFile(pkg *common.Package, controlDir *common.FSDirectory) error
 		desc = strings.TrimSpace(pkg.Name) //description field is strictly required
 	}
 	contents += fmt.Sprintf("Description: %s\n %s\n", desc, desc)
+	//localized descriptions are usually only found in Translation files in
+	//the repository, but repository tools can take them from here
+	for _, locale := range pkg.DescriptionLocales() {
+		desc := strings.TrimSpace(strings.Replace(pkg.LocalizedDescriptions[locale], "\n", " ", -1))
+		contents += fmt.Sprintf("Description-%s: %s\n %s\n", locale, desc, desc)
+	}
 
 	controlDir.Entries["control"] = &common.FSRegularFile{
 		Content:  contents,
@@ -206,28 +437,42 @@ func compilePackageRelations(relType string, rels []common.PackageRelation) (str
 	entries := make([]string, 0, len(rels))
 	//foreach related package...
 	for _, rel := range rels {
-		name := rel.RelatedPackage
-
-		//...compile constraints into a list like ">= 2.4, << 3.0" (operators "<" and ">" become "<<" and ">>" here)
-		if len(rel.Constraints) > 0 {
-			for _, c := range rel.Constraints {
-				operator := c.Relation
-				if operator == "<" {
-					operator = "<<"
-				}
-				if operator == ">" {
-					operator = ">>"
-				}
-				entries = append(entries, fmt.Sprintf("%s (%s %s)", name, operator, c.Version))
+		//alternatives are compiled into a list like "foo (>= 2.4) | bar"
+		if rel.HasAlternatives() {
+			alternatives := make([]string, 0, len(rel.Alternatives))
+			for _, alt := range rel.Alternatives {
+				alternatives = append(alternatives, compileRelationEntries(alt)...)
 			}
-		} else {
-			entries = append(entries, name)
+			entries = append(entries, strings.Join(alternatives, " | "))
+			continue
 		}
+		entries = append(entries, compileRelationEntries(rel)...)
 	}
 
 	return fmt.Sprintf("%s: %s\n", relType, strings.Join(entries, ", ")), nil
 }
 
+func compileRelationEntries(rel common.PackageRelation) []string {
+	name := rel.RelatedPackage
+	if len(rel.Constraints) == 0 {
+		return []string{name}
+	}
+
+	//compile constraints into a list like ">= 2.4, << 3.0" (operators "<" and ">" become "<<" and ">>" here)
+	entries := make([]string, 0, len(rel.Constraints))
+	for _, c := range rel.Constraints {
+		operator := c.Relation
+		if operator == "<" {
+			operator = "<<"
+		}
+		if operator == ">" {
+			operator = ">>"
+		}
+		entries = append(entries, fmt.Sprintf("%s (%s %s)", name, operator, c.Version))
+	}
+	return entries
+}
+
 func writeMD5SumsFile(pkg *common.Package, controlDir *common.FSDirectory) {
 	//calculate MD5 sums for all regular files in this package
 	var lines []string
@@ -246,10 +491,34 @@ func writeMD5SumsFile(pkg *common.Package, controlDir *common.FSDirectory) {
 	}
 }
 
-func buildArArchive(entries []arArchiveEntry) ([]byte, error) {
+//writeConffilesFile lists the configuration files of this package, so that
+//dpkg preserves changes made by the user (and dpkg-maintscript-helper can
+//migrate them, see compileConffileMigrations).
+func writeConffilesFile(pkg *common.Package, controlDir *common.FSDirectory) {
+	var lines []string
+	pkg.WalkFSWithAbsolutePaths(func(path string, node common.FSNode) error {
+		if _, ok := node.(*common.FSRegularFile); ok && pkg.IsConfigFile(path) {
+			lines = append(lines, path+"\n")
+		}
+		return nil
+	})
+	if len(lines) == 0 {
+		return
+	}
+
+	controlDir.Entries["conffiles"] = &common.FSRegularFile{
+		Content:  strings.Join(lines, ""),
+		Metadata: common.FSNodeMetadata{Mode: 0644},
+	}
+}
+
+func writeArArchive(w io.Writer, entries []arArchiveEntry) error {
 	//we only need a very small subset of the ar archive format, so we can
 	//directly construct it without requiring an extra library
-	buf := bytes.NewBuffer([]byte("!<arch>\n"))
+	_, err := io.WriteString(w, "!<arch>\n")
+	if err != nil {
+		return err
+	}
 
 	//most fields are static
 	headerFormat := "%-16s"
@@ -261,13 +530,22 @@ func buildArArchive(entries []arArchiveEntry) ([]byte, error) {
 	headerFormat += "\x60\n"       //magic header separator
 
 	for _, entry := range entries {
-		fmt.Fprintf(buf, headerFormat, entry.Name, len(entry.Data))
-		buf.Write(entry.Data)
+		_, err = fmt.Fprintf(w, headerFormat, entry.Name, entry.Size)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(w, entry.Data, entry.Size)
+		if err != nil {
+			return err
+		}
 		//pad data to 2-byte boundary
-		if len(entry.Data)%2 == 1 {
-			buf.Write([]byte{'\n'})
+		if entry.Size%2 == 1 {
+			_, err = w.Write([]byte{'\n'})
+			if err != nil {
+				return err
+			}
 		}
 	}
 
-	return buf.Bytes(), nil
+	return nil
 }
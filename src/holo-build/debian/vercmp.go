@@ -0,0 +1,150 @@
+/*******************************************************************************
+*
+* Copyright 2018 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package debian
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/holocm/holo-build/src/holo-build/common"
+)
+
+//CompareVersions implements the common.VersionComparer interface.
+func (g *Generator) CompareVersions(a, b string) int {
+	return CompareVersions(a, b)
+}
+
+//FullVersionString implements the common.VersionComparer interface.
+func (g *Generator) FullVersionString(pkg *common.Package) string {
+	return fullVersionString(pkg)
+}
+
+//CompareVersions compares two Debian version strings of the form
+//"[epoch:]upstream_version[-debian_revision]" in the same way as
+//`dpkg --compare-versions`, and returns -1, 0 or 1.
+func CompareVersions(a, b string) int {
+	epochA, upstreamA, revisionA := splitVersion(a)
+	epochB, upstreamB, revisionB := splitVersion(b)
+
+	switch {
+	case epochA < epochB:
+		return -1
+	case epochA > epochB:
+		return 1
+	}
+	if result := compareVersionPart(upstreamA, upstreamB); result != 0 {
+		return result
+	}
+	return compareVersionPart(revisionA, revisionB)
+}
+
+//splitVersion splits a version string into epoch, upstream version and
+//revision like parseversion() in dpkg.
+func splitVersion(version string) (epoch uint64, upstream, revision string) {
+	if idx := strings.Index(version, ":"); idx >= 0 {
+		//an invalid epoch is treated as 0 (the version format is checked by
+		//Validate() anyway)
+		epoch, _ = strconv.ParseUint(version[:idx], 10, 32)
+		version = version[idx+1:]
+	}
+	if idx := strings.LastIndex(version, "-"); idx >= 0 {
+		return epoch, version[:idx], version[idx+1:]
+	}
+	return epoch, version, ""
+}
+
+//order assigns the sort weight of a non-digit character like order() in dpkg:
+//the end of the string sorts before everything except "~", and letters sort
+//before all other characters.
+func order(str string, idx int) int {
+	if idx >= len(str) {
+		return 0
+	}
+	c := str[idx]
+	switch {
+	case isDigit(c):
+		return 0
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return int(c)
+	case c == '~':
+		return -1
+	default:
+		return int(c) + 256
+	}
+}
+
+//compareVersionPart compares upstream versions or revisions like verrevcmp()
+//in dpkg, i.e. by alternating between non-digit and digit segments.
+func compareVersionPart(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		//compare non-digit prefix
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			ac, bc := order(a, i), order(b, j)
+			if ac != bc {
+				return sign(ac - bc)
+			}
+			i++
+			j++
+		}
+
+		//compare numeric segment (ignoring leading zeros)
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		firstDiff := 0
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return sign(firstDiff)
+		}
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func sign(x int) int {
+	switch {
+	case x < 0:
+		return -1
+	case x > 0:
+		return 1
+	default:
+		return 0
+	}
+}
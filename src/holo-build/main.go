@@ -34,17 +34,36 @@ import (
 )
 
 type options struct {
-	generator      common.Generator
-	inputFileName  string //or "" for stdin
-	outputFileName string //or "" for automatic or "-" for stdout
-	filenameOnly   bool
-	withForce      bool
+	generator         common.Generator
+	vercmpArgs        []string //if not nil, run `holo-build vercmp` instead
+	formatName        string
+	groupResolver     common.GroupResolver
+	relationMap       common.RelationMap //or nil to use the one from the package definition
+	relationMapSource common.SourceFile  //only set if relationMap is not nil
+	strictRelationMap bool
+	inputFileName     string //or "" for stdin
+	outputFileName    string //or "" for automatic or "-" for stdout
+	filenameOnly      bool
+	sbomMode          string                    //"none", "sidecar" or "embed"
+	provenance        *common.ProvenanceOptions //or nil to not write a provenance statement
+	compression       common.Compression        //or CompressionDefault to use the one from the package definition
+	compressionOpts   common.CompressionOptions
+	withForce         bool
 }
 
 func main() {
 	opts := parseArgs()
 	generator := opts.generator
 
+	//`holo-build vercmp` does not need a package definition
+	if opts.vercmpArgs != nil {
+		//all generators implement VersionComparer (this is only a type
+		//assertion to keep VersionComparer out of the Generator interface)
+		cmp := generator.(common.VersionComparer)
+		fmt.Println(cmp.CompareVersions(opts.vercmpArgs[0], opts.vercmpArgs[1]))
+		return
+	}
+
 	//read package definition from stdin
 	input := io.Reader(os.Stdin)
 	baseDirectory := "."
@@ -59,10 +78,39 @@ func main() {
 	}
 	pkg, errs := common.ParsePackageDefinition(input, baseDirectory)
 
-	//try to validate package
+	//expand references to package groups, translate related package names
+	//into the selected format, add generated relations, then try to validate
+	//package (group members need to go through all of these steps, too)
 	var validateErrs []error
 	if pkg != nil {
-		validateErrs = generator.Validate(pkg)
+		err := pkg.ResolvePackageGroups(opts.groupResolver)
+		if err != nil {
+			validateErrs = append(validateErrs, err)
+		}
+		relationMap := opts.relationMap
+		if relationMap == nil {
+			relationMap = pkg.RelationMap
+		} else {
+			pkg.SourceFiles = append(pkg.SourceFiles, opts.relationMapSource)
+		}
+		if opts.compression != common.CompressionDefault {
+			pkg.Compression = opts.compression
+		}
+		pkg.CompressionOptions = opts.compressionOpts
+		validateErrs = append(validateErrs, pkg.MapRelationNames(relationMap, opts.formatName, opts.strictRelationMap)...)
+		validateErrs = append(validateErrs, pkg.AddSharedLibraryRelations(opts.formatName)...)
+		validateErrs = append(validateErrs, pkg.AddInterpreterRelations(generator, opts.formatName)...)
+		validateErrs = append(validateErrs, generator.Validate(pkg)...)
+
+		//look for contradictions between relations (this only makes sense
+		//when all versions are known to be valid)
+		if cmp, ok := generator.(common.VersionComparer); ok && len(errs)+len(validateErrs) == 0 {
+			analysisErrs, warnings := pkg.AnalyzeRelations(cmp)
+			for _, warning := range warnings {
+				common.ShowWarning(warning)
+			}
+			validateErrs = append(validateErrs, analysisErrs...)
+		}
 	}
 	errs = append(errs, validateErrs...)
 
@@ -94,19 +142,69 @@ func main() {
 		}
 	}
 
-	//build package
-	pkgBytes, err := pkg.Build(generator)
+	//warn when a previous build of this package has a newer version
+	newerBuilds, err := pkg.FindNewerBuilds(generator, pkgFile)
+	if err != nil {
+		showError(err)
+	}
+	for _, newerBuild := range newerBuilds {
+		common.ShowWarning(fmt.Sprintf("%s has a newer version than %s; this build will be considered a downgrade", newerBuild, pkgFile))
+	}
+
+	//an embedded SBOM must be added before the package is built (a sidecar SBOM
+	//is generated afterwards, so it also covers files added by the generator)
+	if opts.sbomMode == "embed" {
+		err := pkg.EmbedSBOM(generator, opts.formatName)
+		if err != nil {
+			showErrorMsg("cannot build %s: %s", pkgFile, err.Error())
+			os.Exit(2)
+		}
+	}
+
+	//build package (directly into the output file, since it might be too large
+	//to be held in memory)
+	output, err := common.CreateOutputFile(pkgFile, opts.withForce)
+	if err != nil {
+		showErrorMsg("cannot write %s: %s", pkgFile, err.Error())
+		os.Exit(2)
+	}
+	err = pkg.Build(generator, output)
 	if err != nil {
+		output.Abort()
 		showErrorMsg("cannot build %s: %s", pkgFile, err.Error())
 		os.Exit(2)
 	}
 
-	wasWritten, err := pkg.WriteOutput(generator, pkgBytes, pkgFile, opts.withForce)
+	wasWritten, err := output.Commit()
 	if err != nil {
 		showErrorMsg("cannot write %s: %s", pkgFile, err.Error())
 		os.Exit(2)
 	}
 
+	if opts.sbomMode == "sidecar" {
+		sbomFile := pkgFile + ".spdx.json"
+		sbomBytes, err := pkg.MakeSBOM(generator, opts.formatName)
+		if err == nil {
+			_, err = common.WriteOutput(sbomBytes, sbomFile, opts.withForce)
+		}
+		if err != nil {
+			showErrorMsg("cannot write %s: %s", sbomFile, err.Error())
+			os.Exit(2)
+		}
+	}
+
+	if opts.provenance != nil {
+		provenanceFile := pkgFile + ".intoto.json"
+		provenanceBytes, err := pkg.MakeProvenance(pkgFile, output.SHA256Digest(), *opts.provenance)
+		if err == nil {
+			_, err = common.WriteOutput(provenanceBytes, provenanceFile, opts.withForce)
+		}
+		if err != nil {
+			showErrorMsg("cannot write %s: %s", provenanceFile, err.Error())
+			os.Exit(2)
+		}
+	}
+
 	if !wasWritten {
 		os.Exit(0)
 	}
@@ -127,6 +225,16 @@ func parseArgs() options {
 	reproducible := pflag.Bool("reproducible", false, "Deprecated, no effect")
 	noReproducible := pflag.Bool("no-reproducible", false, "Deprecated, no effect")
 	suggestFileName := pflag.Bool("suggest-filename", false, "Only print the suggested filename for this package")
+	pacmanAlternatives := pflag.String("pacman-alternatives", "error", "How to handle alternative requirements for pacman (\"error\" or \"first\")")
+	relationMapFile := pflag.String("relation-map", "", "Rename related packages according to this TOML file (overrides package.relationMap)")
+	strictRelationMap := pflag.Bool("strict-relation-map", false, "Fail when a related package is missing from the relation map")
+	packageGroups := pflag.String("package-groups", "", "Resolve package groups from this pacman sync database, directory of sync databases, or TOML file (instead of asking pacman)")
+	compression := pflag.String("compression", "", "Compress the package contents with this format (\"none\", \"gzip\", \"lzma\", \"xz\" or \"zstd\"; overrides package.compression)")
+	compressionLevel := pflag.String("compression-level", "", "Compression level (a number, or \"fast\" for the fastest level of the compression format)")
+	jobs := pflag.IntP("jobs", "j", 0, "Number of threads for compression (default: one per CPU; does not change the result)")
+	sbomMode := pflag.String("sbom", "none", "Generate an SPDX software bill of materials (\"none\", \"sidecar\" or \"embed\")")
+	provenance := pflag.Bool("provenance", false, "Write an in-toto provenance statement next to the package")
+	provenanceKey := pflag.String("provenance-key", "", "Sign the provenance statement with the Ed25519 private key in this PEM file")
 	showVersion := pflag.BoolP("version", "V", false, "Show program version")
 
 	pflag.Parse()
@@ -183,12 +291,97 @@ func parseArgs() options {
 		*formatString = "rpm"
 	}
 
+	var useFirstAlternative bool
+	switch *pacmanAlternatives {
+	case "error":
+		useFirstAlternative = false
+	case "first":
+		useFirstAlternative = true
+	default:
+		showErrorMsg("Invalid value for --pacman-alternatives: '%s'", *pacmanAlternatives)
+		hasArgsError = true
+	}
+
+	switch *sbomMode {
+	case "none", "embed":
+		//no additional checks
+	case "sidecar":
+		if *outputFileName == "-" {
+			showErrorMsg("--sbom=sidecar cannot be used when writing the package to standard output")
+			hasArgsError = true
+		}
+	default:
+		showErrorMsg("Invalid value for --sbom: '%s'", *sbomMode)
+		hasArgsError = true
+	}
+
+	var provenanceOpts *common.ProvenanceOptions
+	if *provenance {
+		provenanceOpts = &common.ProvenanceOptions{
+			Format:    *formatString,
+			Arguments: os.Args[1:],
+		}
+		if *outputFileName == "-" {
+			showErrorMsg("--provenance cannot be used when writing the package to standard output")
+			hasArgsError = true
+		}
+		if *provenanceKey != "" {
+			key, err := common.ReadSigningKey(*provenanceKey)
+			if err != nil {
+				showErrorMsg("Invalid value for --provenance-key: %s", err.Error())
+				hasArgsError = true
+			}
+			provenanceOpts.SigningKey = key
+		}
+	} else if *provenanceKey != "" {
+		showErrorMsg("--provenance-key requires --provenance")
+		hasArgsError = true
+	}
+
+	groupResolver, err := common.NewGroupResolver(*packageGroups)
+	if err != nil {
+		showErrorMsg("Invalid value for --package-groups: %s", err.Error())
+		hasArgsError = true
+	}
+
+	var relationMap common.RelationMap
+	var relationMapSource common.SourceFile
+	if *relationMapFile != "" {
+		relationMap, relationMapSource, err = common.ReadRelationMap(*relationMapFile)
+		if err != nil {
+			showError(err)
+			hasArgsError = true
+		}
+	}
+
+	var compressionValue common.Compression
+	if *compression != "" {
+		compressionValue, err = common.ParseCompression(*compression)
+		if err != nil {
+			showErrorMsg("Invalid value for --compression: %s", err.Error())
+			hasArgsError = true
+		}
+	}
+
+	compressionOpts := common.CompressionOptions{Jobs: *jobs}
+	if *compressionLevel != "" {
+		compressionOpts.Level, err = common.ParseCompressionLevel(*compressionLevel)
+		if err != nil {
+			showErrorMsg("Invalid value for --compression-level: %s", err.Error())
+			hasArgsError = true
+		}
+	}
+	if *jobs < 0 {
+		showErrorMsg("Invalid value for --jobs: %d", *jobs)
+		hasArgsError = true
+	}
+
 	var generator common.Generator
 	switch *formatString {
 	case "debian":
 		generator = &debian.Generator{}
 	case "pacman":
-		generator = &pacman.Generator{}
+		generator = &pacman.Generator{UseFirstAlternative: useFirstAlternative}
 	case "rpm":
 		generator = &rpm.Generator{}
 	case "":
@@ -200,25 +393,47 @@ func parseArgs() options {
 	}
 
 	var inputFileName string
-	switch len(pflag.Args()) {
-	case 0:
+	var vercmpArgs []string
+	switch {
+	case len(pflag.Args()) == 0:
 		inputFileName = "" //use stdin
-	case 1:
+	case len(pflag.Args()) == 1:
 		inputFileName = pflag.Arg(0)
+	case pflag.Arg(0) == "vercmp":
+		if len(pflag.Args()) == 3 {
+			vercmpArgs = pflag.Args()[1:]
+		} else {
+			showErrorMsg("Usage: holo-build vercmp --format=<format> <version1> <version2>")
+			hasArgsError = true
+		}
 	default:
 		showErrorMsg("Multiple input files specified.")
 		hasArgsError = true
 	}
 
+	if provenanceOpts != nil {
+		provenanceOpts.InputFileName = inputFileName
+	}
+
 	if hasArgsError {
 		os.Exit(1)
 	}
 	return options{
-		generator:      generator,
-		inputFileName:  inputFileName,
-		outputFileName: *outputFileName,
-		filenameOnly:   *suggestFileName,
-		withForce:      *withForce,
+		generator:         generator,
+		vercmpArgs:        vercmpArgs,
+		formatName:        *formatString,
+		groupResolver:     groupResolver,
+		relationMap:       relationMap,
+		relationMapSource: relationMapSource,
+		strictRelationMap: *strictRelationMap,
+		compression:       compressionValue,
+		compressionOpts:   compressionOpts,
+		inputFileName:     inputFileName,
+		outputFileName:    *outputFileName,
+		filenameOnly:      *suggestFileName,
+		sbomMode:          *sbomMode,
+		provenance:        provenanceOpts,
+		withForce:         *withForce,
 	}
 }
 
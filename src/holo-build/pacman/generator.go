@@ -22,6 +22,7 @@ package pacman
 
 import (
 	"fmt"
+	"io"
 	"regexp"
 	"sort"
 	"strings"
@@ -31,7 +32,13 @@ import (
 
 //Generator is the common.Generator for Pacman packages (as used by Arch Linux
 //and derivatives).
-type Generator struct{}
+type Generator struct {
+	//UseFirstAlternative decides what to do with alternative requirements
+	//like "foo | bar", which cannot be expressed in pacman packages. If false,
+	//such requirements fail validation. If true, only the first alternative
+	//is required.
+	UseFirstAlternative bool
+}
 
 var archMap = map[common.Architecture]string{
 	common.ArchitectureAny:     "any",
@@ -41,47 +48,185 @@ var archMap = map[common.Architecture]string{
 	common.ArchitectureARMv6h:  "armv6h",
 	common.ArchitectureARMv7h:  "armv7h",
 	common.ArchitectureAArch64: "aarch64",
+	common.ArchitecturePPC64LE: "powerpc64le", //from Arch POWER
+	// common.ArchitectureS390X is not supported by any Arch Linux port
+	common.ArchitectureRISCV64:  "riscv64",  //from Arch Linux RISC-V
+	common.ArchitectureMIPS64EL: "mips64el", //from Arch Linux MIPS
+	common.ArchitectureLoong64:  "loong64",  //from Loong Arch Linux
+}
+
+//ArchitectureName implements the common.Generator interface.
+func (g *Generator) ArchitectureName(pkg *common.Package) string {
+	return pkg.ArchitectureName(archMap)
 }
 
 //RecommendedFileName implements the common.Generator interface.
 func (g *Generator) RecommendedFileName(pkg *common.Package) string {
 	//this is called after Build(), so we can assume that package name,
 	//version, etc. were already validated
-	return fmt.Sprintf("%s-%s-%s.pkg.tar.xz", pkg.Name, fullVersionString(pkg), archMap[pkg.Architecture])
+	return fmt.Sprintf("%s-%s-%s.pkg.tar%s", pkg.Name, fullVersionString(pkg), pkg.ArchitectureName(archMap), compression(pkg).FileExtension())
+}
+
+//compression returns the compression format for the package (XZ unless
+//requested otherwise, since older pacman versions cannot read zstd).
+func compression(pkg *common.Package) common.Compression {
+	return pkg.Compression.Or(common.CompressionXZ)
 }
 
 //Validate implements the common.Generator interface.
 func (g *Generator) Validate(pkg *common.Package) []error {
 	var nameRx = `[a-z0-9@._+][a-z0-9@._+-]*`
 	var versionRx = `[a-zA-Z0-9._]+`
-	return pkg.ValidateWith(common.RegexSet{
+	errs := pkg.ValidateWith(common.RegexSet{
 		PackageName:    nameRx,
 		PackageVersion: versionRx,
-		RelatedName:    "(?:except:)?(?:group:)?" + nameRx,
+		RelatedName:    nameRx,
 		RelatedVersion: "(?:[0-9]+:)?" + versionRx + "(?:-[1-9][0-9]*)?", //incl. release/epoch
 		FormatName:     "pacman",
 	}, archMap)
+	errs = append(errs, pkg.ValidateCompression("pacman",
+		common.CompressionNone, common.CompressionGzip, common.CompressionXZ, common.CompressionZstd)...)
+	errs = append(errs, pkg.ValidateCompressionLevel(compression(pkg))...)
+
+	//pacman has no syntax for alternatives
+	for _, rel := range allRequirements(pkg) {
+		if !rel.HasAlternatives() {
+			continue
+		}
+		if !g.UseFirstAlternative {
+			errs = append(errs, fmt.Errorf("alternative requirements like \"%s\" are not supported for pacman packages (use --pacman-alternatives=first to require only the first alternative)", describeAlternatives(rel)))
+		}
+	}
+
+	//alternatives are added to provides and conflicts during Build(), so their
+	//names must be acceptable as related package names
+	altNameRx := regexp.MustCompile("^" + nameRx + "$")
+	for _, alt := range pkg.Alternatives {
+		if !altNameRx.MatchString(alt.Name) {
+			errs = append(errs, fmt.Errorf("alternative name \"%s\" is not acceptable for pacman packages", alt.Name))
+		}
+	}
+
+	//pacman refuses to install files owned by other packages, and there is no
+	//way to move them out of the way without breaking the other package
+	for _, path := range pkg.Diversions {
+		errs = append(errs, fmt.Errorf("file diversions are not supported for pacman packages (found on \"%s\")", path))
+	}
+
+	return errs
+}
+
+//describeAlternatives formats alternative requirements like "foo>=2.0 | bar"
+//for use in error messages.
+func describeAlternatives(rel common.PackageRelation) string {
+	terms := make([]string, 0, len(rel.Alternatives))
+	for _, alt := range rel.Alternatives {
+		term := alt.RelatedPackage
+		for _, c := range alt.Constraints {
+			term += c.Relation + c.Version
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " | ")
+}
+
+//allRequirements returns pkg.Requires and pkg.PreDepends in one list, since
+//pacman always installs dependencies before their dependents anyway.
+func allRequirements(pkg *common.Package) []common.PackageRelation {
+	return append(append([]common.PackageRelation{}, pkg.Requires...), pkg.PreDepends...)
+}
+
+//firstAlternatives replaces all alternative requirements by their first
+//alternative. Validate() ensures that this is only done when the user asked
+//for it.
+func firstAlternatives(rels []common.PackageRelation) []common.PackageRelation {
+	result := make([]common.PackageRelation, 0, len(rels))
+	for _, rel := range rels {
+		if rel.HasAlternatives() {
+			rel = rel.Alternatives[0]
+		}
+		result = append(result, rel)
+	}
+	return result
 }
 
 //Build implements the common.Generator interface.
-func (g *Generator) Build(pkg *common.Package) ([]byte, error) {
+func (g *Generator) Build(pkg *common.Package, w io.Writer) error {
+	//pacman does not have an alternatives system, so alternatives are
+	//implemented as plain symlinks that conflict with each other
+	err := materializeAlternatives(pkg)
+	if err != nil {
+		return err
+	}
+
+	appendActions(pkg)
+
+	//license files go into /usr/share/licenses like in makepkg
+	err = pkg.InsertLicenseFile()
+	if err != nil {
+		return err
+	}
+
 	//write .PKGINFO
-	err := writePKGINFO(pkg)
+	err = writePKGINFO(pkg)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to write .PKGINFO: %s", err.Error())
+		return fmt.Errorf("Failed to write .PKGINFO: %s", err.Error())
 	}
 
-	//write .INSTALL
+	//write .INSTALL and .CHANGELOG
 	writeINSTALL(pkg)
+	writeCHANGELOG(pkg)
 
 	//write mtree
 	err = writeMTREE(pkg)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to write .MTREE: %s", err.Error())
+		return fmt.Errorf("Failed to write .MTREE: %s", err.Error())
 	}
 
 	//compress package
-	return pkg.FSRoot.ToTarXZArchive(false, true)
+	return pkg.FSRoot.WriteCompressedTarArchive(w, compression(pkg), pkg.CompressionOptions, false, true)
+}
+
+//appendActions adds the actions that this generator needs in addition to
+//the ones from the package definition.
+func appendActions(pkg *common.Package) {
+	//pacman does not know ghost files, so delete them when the package is
+	//removed
+	pkg.AppendActions(pkg.GhostFileCleanupActions()...)
+}
+
+//MetadataFiles implements the common.MetadataFileLister interface.
+func (g *Generator) MetadataFiles() []string {
+	return []string{".PKGINFO", ".INSTALL", ".CHANGELOG", ".MTREE"}
+}
+
+//scriptletShell is the shell that pacman runs the .INSTALL file with (this is
+//SCRIPTLET_SHELL in pacman's build configuration).
+const scriptletShell = "/bin/sh"
+
+//MaintainerScripts implements the common.Generator interface.
+func (g *Generator) MaintainerScripts(pkg *common.Package) map[string]string {
+	p := pkg.WithBuildActions()
+	appendActions(p)
+	contents := compileINSTALL(p)
+	if contents == "" {
+		return nil
+	}
+	return map[string]string{".INSTALL": "#!" + scriptletShell + "\n" + contents}
+}
+
+func materializeAlternatives(pkg *common.Package) error {
+	ec := &common.ErrorCollector{}
+	for _, alt := range pkg.Alternatives {
+		pkg.InsertFSNode(&common.FSSymlink{Target: alt.Path}, alt.Link, ec)
+		rel := common.PackageRelation{RelatedPackage: alt.Name}
+		pkg.Provides = append(pkg.Provides, rel)
+		pkg.Conflicts = append(pkg.Conflicts, rel)
+	}
+	if len(ec.Errors) > 0 {
+		return ec.Errors[0]
+	}
+	return nil
 }
 
 func fullVersionString(pkg *common.Package) string {
@@ -108,27 +253,20 @@ func writePKGINFO(pkg *common.Package) error {
 		contents += fmt.Sprintf("packager = %s\n", pkg.Author)
 	}
 	contents += fmt.Sprintf("size = %d\n", pkg.FSRoot.InstalledSizeInBytes())
-	contents += fmt.Sprintf("arch = %s\n", archMap[pkg.Architecture])
-	contents += "license = custom:none\n"
-	replaces, err := compilePackageRequirements("replaces", pkg.Replaces)
-	if err != nil {
-		return err
-	}
-	conflicts, err := compilePackageRequirements("conflict", pkg.Conflicts)
-	if err != nil {
-		return err
-	}
-	provides, err := compilePackageRequirements("provides", pkg.Provides)
-	if err != nil {
-		return err
+	contents += fmt.Sprintf("arch = %s\n", pkg.ArchitectureName(archMap))
+	if terms := pkg.LicenseTerms(); len(terms) > 0 {
+		for _, term := range terms {
+			contents += fmt.Sprintf("license = %s\n", term)
+		}
+	} else {
+		contents += "license = custom:none\n"
 	}
-	contents += replaces + conflicts + provides
+	contents += compilePackageRelations("replaces", pkg.Replaces)
+	//pacman has no concept of "breaks", but "conflicts" is close enough
+	contents += compilePackageRelations("conflict", append(append([]common.PackageRelation{}, pkg.Conflicts...), pkg.Breaks...))
+	contents += compilePackageRelations("provides", pkg.Provides)
 	contents += compileBackupMarkers(pkg)
-	requires, err := compilePackageRequirements("depend", pkg.Requires)
-	if err != nil {
-		return err
-	}
-	contents += requires
+	contents += compilePackageRelations("depend", firstAlternatives(allRequirements(pkg)))
 
 	//we used holo-build to build this, so the build depends on this package
 	contents += "makedepend = holo-build\n"
@@ -158,7 +296,8 @@ func compileBackupMarkers(pkg *common.Package) string {
 		if _, ok := node.(*common.FSRegularFile); !ok {
 			return nil //look only at regular files
 		}
-		if !strings.HasPrefix(path, "usr/share/holo/") {
+		//the license file is not a configuration file
+		if !strings.HasPrefix(path, "usr/share/holo/") && "/"+path != pkg.LicenseFilePath() {
 			lines = append(lines, fmt.Sprintf("backup = %s\n", path))
 		}
 		return nil
@@ -167,17 +306,33 @@ func compileBackupMarkers(pkg *common.Package) string {
 	return strings.Join(lines, "")
 }
 
-func writeINSTALL(pkg *common.Package) {
-	//assemble the contents for the .INSTALL file
-	contents := ""
-	if script := pkg.Script(common.SetupAction); script != "" {
-		contents += fmt.Sprintf("post_install() {\n%s\n}\npost_upgrade() {\npost_install\n}\n", script)
-	}
-	if script := pkg.Script(common.CleanupAction); script != "" {
-		contents += fmt.Sprintf("post_remove() {\n%s\n}\n", script)
+//compileConffileMigrations returns the part of the post_upgrade() function
+//that handles pkg.ConffileMigrations. When a modified configuration file is
+//removed by an upgrade, pacman keeps it as .pacsave, so move it to the new
+//location (and keep the new default configuration as .pacnew, like pacman
+//does for conflicting changes). Removed files can stay as .pacsave.
+func compileConffileMigrations(pkg *common.Package) string {
+	var snippets []string
+	for _, m := range pkg.ConffileMigrations {
+		if m.To == "" {
+			continue
+		}
+		//post_upgrade() gets the new and old version as arguments
+		since := m.Since
+		if pkg.Epoch > 0 {
+			since = fmt.Sprintf("%d:%s", pkg.Epoch, since)
+		}
+		snippets = append(snippets, fmt.Sprintf(
+			"if [ \"$(vercmp \"$2\" %s)\" -lt 0 ] && [ -e %s.pacsave ]; then\nmv -f %s %s.pacnew\nmv -f %s.pacsave %s\nfi",
+			since, m.From, m.To, m.To, m.From, m.To,
+		))
 	}
+	return strings.Join(snippets, "\n")
+}
 
+func writeINSTALL(pkg *common.Package) {
 	//do we need the .INSTALL file at all?
+	contents := compileINSTALL(pkg)
 	if contents == "" {
 		return
 	}
@@ -188,6 +343,57 @@ func writeINSTALL(pkg *common.Package) {
 	}
 }
 
+//compileINSTALL returns the contents for the .INSTALL file, or "" if none is
+//needed.
+func compileINSTALL(pkg *common.Package) string {
+	contents := ""
+	setupScript := pkg.Script(common.SetupAction)
+	if setupScript != "" {
+		contents += fmt.Sprintf("post_install() {\n%s\n}\n", setupScript)
+	}
+	if migrations := compileConffileMigrations(pkg); migrations != "" {
+		if setupScript != "" {
+			migrations += "\npost_install"
+		}
+		contents += fmt.Sprintf("post_upgrade() {\n%s\n}\n", migrations)
+	} else if setupScript != "" {
+		contents += "post_upgrade() {\npost_install\n}\n"
+	}
+	//pacman does not distinguish between removal and purging, and
+	//post_remove() is not called on upgrade
+	removeScript := strings.TrimSpace(pkg.Script(common.CleanupAction) + "\n" + pkg.Script(common.PurgeAction))
+	if removeScript != "" {
+		contents += fmt.Sprintf("post_remove() {\n%s\n}\n", removeScript)
+	}
+	if script := pkg.Script(common.PreCleanupAction); script != "" {
+		contents += fmt.Sprintf("pre_remove() {\n%s\n}\n", script)
+	}
+	return contents
+}
+
+//writeCHANGELOG renders the package's changelog in the same form as the
+//%changelog section of an RPM spec file, since pacman does not prescribe any
+//format for the .CHANGELOG file.
+func writeCHANGELOG(pkg *common.Package) {
+	if len(pkg.Changelog) == 0 {
+		return
+	}
+
+	var paragraphs []string
+	for _, entry := range pkg.Changelog {
+		lines := []string{fmt.Sprintf("* %s %s - %s", entry.Date.Format("Mon Jan 02 2006"), entry.Author, entry.Version)}
+		for _, text := range entry.Entries {
+			lines = append(lines, "- "+strings.Replace(text, "\n", "\n  ", -1))
+		}
+		paragraphs = append(paragraphs, strings.Join(lines, "\n")+"\n")
+	}
+
+	pkg.FSRoot.Entries[".CHANGELOG"] = &common.FSRegularFile{
+		Content:  strings.Join(paragraphs, "\n"),
+		Metadata: common.FSNodeMetadata{Mode: 0644},
+	}
+}
+
 func writeMTREE(pkg *common.Package) error {
 	contents, err := MakeMTREE(pkg)
 	if err != nil {
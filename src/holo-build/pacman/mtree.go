@@ -73,8 +73,11 @@ func MakeMTREE(pkg *common.Package) ([]byte, error) {
 				line += fmt.Sprintf(" mode=%o", n.Metadata.Mode)
 			}
 			line += fmt.Sprintf(" size=%d md5digest=%s sha256digest=%s",
-				len([]byte(n.Content)), n.MD5Digest(), n.SHA256Digest(),
+				n.Size(), n.MD5Digest(), n.SHA256Digest(),
 			)
+		case *common.FSGhostFile:
+			//not shipped in the package
+			return nil
 		case *common.FSSymlink:
 			// uid=0 gid=0 is default
 			line += " type=link mode=777"
@@ -22,9 +22,6 @@ package pacman
 
 import (
 	"fmt"
-	"os"
-	"os/exec"
-	"sort"
 	"strings"
 
 	"github.com/holocm/holo-build/src/holo-build/common"
@@ -50,87 +47,3 @@ func compilePackageRelations(relType string, rels []common.PackageRelation) stri
 	}
 	return strings.Join(lines, "\n") + "\n"
 }
-
-//Like compilePackageRelations, but resolve special syntax for requirements
-//(references to groups, exclusion of packages and groups).
-func compilePackageRequirements(relType string, rels []common.PackageRelation) (string, error) {
-	//acceptRel marks which packages will be included in the result
-	//(e.g. "not:foo" sets acceptPkg["foo"] = false)
-	acceptPkg := make(map[string]bool, len(rels))
-
-	//read all input relations, and filter plain package relations (those that
-	//are not groups or negations)
-	actualRels := make([]common.PackageRelation, len(rels))
-	for _, rel := range rels {
-		name := rel.RelatedPackage
-		isNegated := strings.HasPrefix(name, "except:")
-		name = strings.TrimPrefix(name, "except:")
-		isGroup := strings.HasPrefix(name, "group:")
-		name = strings.TrimPrefix(name, "group:")
-
-		if isGroup {
-			//resolve groups
-			pkgs, err := resolvePackageGroup(name)
-			if err != nil {
-				return "", err
-			}
-
-			//accept packages in this group if not negated
-			for _, pkgName := range pkgs {
-				acceptPkg[pkgName] = !isNegated
-			}
-		} else {
-			acceptPkg[name] = !isNegated
-			if !isNegated {
-				actualRels = append(actualRels, rel)
-			}
-		}
-	}
-
-	//prune all not-accepted packages from actualRels
-	prunedRels := make([]common.PackageRelation, 0, len(actualRels))
-	for _, rel := range actualRels {
-		if acceptPkg[rel.RelatedPackage] {
-			prunedRels = append(prunedRels, rel)
-		}
-		delete(acceptPkg, rel.RelatedPackage)
-	}
-
-	//add all missing relations (these are all pkgName with acceptPkg[pkgName]
-	//= true since we removed existing rels from acceptPkg in the last step)
-	additionalRels := make([]common.PackageRelation, 0, len(acceptPkg))
-	for pkgName, accepted := range acceptPkg {
-		if accepted {
-			additionalRels = append(additionalRels, common.PackageRelation{RelatedPackage: pkgName})
-		}
-	}
-	sort.Sort(byRelatedPackage(additionalRels))
-	prunedRels = append(prunedRels, additionalRels...)
-
-	return compilePackageRelations(relType, prunedRels), nil
-}
-
-func resolvePackageGroup(groupName string) ([]string, error) {
-	//mock implementation (for unit tests): read package names from group name
-	//(e.g. "group:foo-bar-baz" contains packages "foo", "bar", "baz")
-	if value := os.Getenv("HOLO_MOCK"); value == "1" {
-		return strings.Split(groupName, "-"), nil
-	}
-
-	//actual implementation: call pacman to resolve package groups
-	cmd := exec.Command("pacman", "-Sqg", groupName)
-	cmd.Stderr = os.Stderr
-	out, err := cmd.Output()
-	if err != nil {
-		return nil, err
-	}
-
-	return strings.Fields(string(out)), nil
-}
-
-//implement sort.Sort interface for package relations
-type byRelatedPackage []common.PackageRelation
-
-func (b byRelatedPackage) Len() int           { return len(b) }
-func (b byRelatedPackage) Less(i, j int) bool { return b[i].RelatedPackage < b[j].RelatedPackage }
-func (b byRelatedPackage) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
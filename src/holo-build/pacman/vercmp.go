@@ -0,0 +1,178 @@
+/*******************************************************************************
+*
+* Copyright 2018 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package pacman
+
+import (
+	"strings"
+
+	"github.com/holocm/holo-build/src/holo-build/common"
+)
+
+//CompareVersions implements the common.VersionComparer interface.
+func (g *Generator) CompareVersions(a, b string) int {
+	return CompareVersions(a, b)
+}
+
+//FullVersionString implements the common.VersionComparer interface.
+func (g *Generator) FullVersionString(pkg *common.Package) string {
+	return fullVersionString(pkg)
+}
+
+//CompareVersions compares two pacman version strings of the form
+//"[epoch:]pkgver[-pkgrel]" in the same way as vercmp(8), and returns -1, 0 or
+//1. The pkgrel is only compared if both versions have one.
+func CompareVersions(a, b string) int {
+	epochA, versionA, releaseA := splitVersion(a)
+	epochB, versionB, releaseB := splitVersion(b)
+
+	if result := compareVersionPart(epochA, epochB); result != 0 {
+		return result
+	}
+	if result := compareVersionPart(versionA, versionB); result != 0 {
+		return result
+	}
+	if releaseA == "" || releaseB == "" {
+		return 0
+	}
+	return compareVersionPart(releaseA, releaseB)
+}
+
+//splitVersion splits a version string into epoch, pkgver and pkgrel like
+//parseEVR() in libalpm.
+func splitVersion(version string) (epoch, pkgver, pkgrel string) {
+	epoch = "0"
+	idx := 0
+	for idx < len(version) && isDigit(version[idx]) {
+		idx++
+	}
+	if idx < len(version) && version[idx] == ':' {
+		if idx > 0 {
+			epoch = version[:idx]
+		}
+		version = version[idx+1:]
+	}
+	if idx := strings.LastIndex(version, "-"); idx >= 0 {
+		return epoch, version[:idx], version[idx+1:]
+	}
+	return epoch, version, ""
+}
+
+//compareVersionPart compares version parts like rpmvercmp() in libalpm.
+//Unlike RPM, pacman does not treat "~" specially, and the number of separator
+//characters between segments is significant.
+func compareVersionPart(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	i, j := 0, 0
+	endA, endB := 0, 0 //end of the previous segment
+	for i < len(a) && j < len(b) {
+		for i < len(a) && !isAlnum(a[i]) {
+			i++
+		}
+		for j < len(b) && !isAlnum(b[j]) {
+			j++
+		}
+		if i >= len(a) || j >= len(b) {
+			break
+		}
+
+		//if the separator lengths differ, the longer one wins
+		if i-endA != j-endB {
+			if i-endA < j-endB {
+				return -1
+			}
+			return 1
+		}
+
+		//grab the next segment of the same type in both strings
+		startA, startB := i, j
+		isNum := isDigit(a[i])
+		if isNum {
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+		} else {
+			for i < len(a) && isAlpha(a[i]) {
+				i++
+			}
+			for j < len(b) && isAlpha(b[j]) {
+				j++
+			}
+		}
+		endA, endB = i, j
+
+		//segments of different types: numeric segments are newer than alpha segments
+		if startB == j {
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+
+		if result := compareSegments(a[startA:i], b[startB:j], isNum); result != 0 {
+			return result
+		}
+	}
+
+	//one string is exhausted: an alpha suffix is older than nothing ("1.0a" <
+	//"1.0"), everything else is newer ("1.0.1" > "1.0")
+	switch {
+	case i >= len(a) && j >= len(b):
+		return 0
+	case (i >= len(a) && !isAlpha(b[j])) || (i < len(a) && isAlpha(a[i])):
+		return -1
+	default:
+		return 1
+	}
+}
+
+//compareSegments compares two segments of the same type (both numeric or both
+//alphabetic).
+func compareSegments(a, b string, isNum bool) int {
+	if isNum {
+		a = strings.TrimLeft(a, "0")
+		b = strings.TrimLeft(b, "0")
+		switch {
+		case len(a) < len(b):
+			return -1
+		case len(a) > len(b):
+			return 1
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || isAlpha(c)
+}
@@ -22,6 +22,7 @@ package rpm
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/holocm/holo-build/src/holo-build/common"
 )
@@ -40,36 +41,95 @@ type Generator struct{}
 
 //Source for this data: `grep arch_canon /usr/lib/rpm/rpmrc`
 var archMap = map[common.Architecture]string{
-	common.ArchitectureAny:     "noarch",
-	common.ArchitectureI386:    "i686",
-	common.ArchitectureX86_64:  "x86_64",
-	common.ArchitectureARMv5:   "armv5tl",
-	common.ArchitectureARMv6h:  "armv6hl",
-	common.ArchitectureARMv7h:  "armv7hl",
-	common.ArchitectureAArch64: "aarch64",
+	common.ArchitectureAny:      "noarch",
+	common.ArchitectureI386:     "i686",
+	common.ArchitectureX86_64:   "x86_64",
+	common.ArchitectureARMv5:    "armv5tl",
+	common.ArchitectureARMv6h:   "armv6hl",
+	common.ArchitectureARMv7h:   "armv7hl",
+	common.ArchitectureAArch64:  "aarch64",
+	common.ArchitecturePPC64LE:  "ppc64le",
+	common.ArchitectureS390X:    "s390x",
+	common.ArchitectureRISCV64:  "riscv64",
+	common.ArchitectureMIPS64EL: "mips64el",
+	common.ArchitectureLoong64:  "loongarch64",
 }
 var archIDMap = map[common.Architecture]uint16{
-	common.ArchitectureAny:     0,
-	common.ArchitectureI386:    1,
-	common.ArchitectureX86_64:  1,
-	common.ArchitectureARMv5:   12,
-	common.ArchitectureARMv6h:  12,
-	common.ArchitectureARMv7h:  12,
-	common.ArchitectureAArch64: 12,
+	common.ArchitectureAny:      0,
+	common.ArchitectureI386:     1,
+	common.ArchitectureX86_64:   1,
+	common.ArchitectureARMv5:    12,
+	common.ArchitectureARMv6h:   12,
+	common.ArchitectureARMv7h:   12,
+	common.ArchitectureAArch64:  12,
+	common.ArchitecturePPC64LE:  16,
+	common.ArchitectureS390X:    15,
+	common.ArchitectureRISCV64:  22,
+	common.ArchitectureMIPS64EL: 11,
+	common.ArchitectureLoong64:  23,
+	//ArchitectureRaw is not listed here, so the lead contains 0 (which is
+	//fine since rpm ignores the lead)
 }
 
 //Validate implements the common.Generator interface.
 func (g *Generator) Validate(pkg *common.Package) []error {
-	//TODO, (cannot find a reliable cross-distro source of truth for the
-	//acceptable format of package names and versions)
-	return nil
+	//reference: rpmCharCheck() calls in build/parsePreamble.c in rpm 4.x (the
+	//macro characters "%{}" are not accepted since we don't expand macros)
+	var nameRx = `[a-zA-Z0-9_][a-zA-Z0-9_.+-]*`
+	var versionRx = `[a-zA-Z0-9_.+~^]+`
+	errs := pkg.ValidateWith(common.RegexSet{
+		PackageName:    nameRx,
+		PackageVersion: versionRx,
+		//besides package names, relations can refer to capabilities like
+		//"rpmlib(PayloadIsLzma)", "perl(File::Temp)" or
+		//"libfoo.so.1()(64bit)", and to file paths
+		RelatedName:    `(?:` + nameRx + `(?:\([^\s()]*\))*|(?:/[^\s/]+)+)`,
+		RelatedVersion: "(?:[0-9]+:)?" + versionRx + "(?:-" + versionRx + ")?", //incl. release/epoch
+		FormatName:     "RPM",
+	}, archMap)
+
+	//the name-version-release string must fit into the lead (see NewLead)
+	if nvr := pkg.Name + "-" + fullVersionString(pkg); len(nvr) > 65 {
+		errs = append(errs, fmt.Errorf("Package name and version \"%s\" is too long for RPM packages (%d bytes, max. 65 bytes)", nvr, len(nvr)))
+	}
+
+	errs = append(errs, pkg.ValidateCompression("RPM",
+		common.CompressionGzip, common.CompressionLZMA, common.CompressionXZ, common.CompressionZstd)...)
+	errs = append(errs, pkg.ValidateCompressionLevel(compression(pkg))...)
+
+	//RPM does not have diversions; a file can be shared between packages only
+	//when the contents are identical
+	for _, path := range pkg.Diversions {
+		errs = append(errs, fmt.Errorf("file diversions are not supported for RPM packages (found on \"%s\")", path))
+	}
+
+	//the CPIO payload cannot hold files of 4 GiB or more
+	pkg.WalkFSWithAbsolutePaths(func(path string, node common.FSNode) error {
+		if n, ok := node.(*common.FSRegularFile); ok && n.Size() > maxFileSize {
+			errs = append(errs, fmt.Errorf("file \"%s\" is too large for RPM packages (%d bytes, max. %d bytes)", path, n.Size(), int64(maxFileSize)))
+		}
+		return nil
+	})
+
+	return errs
+}
+
+//ArchitectureName implements the common.Generator interface.
+func (g *Generator) ArchitectureName(pkg *common.Package) string {
+	return pkg.ArchitectureName(archMap)
 }
 
 //RecommendedFileName implements the common.Generator interface.
 func (g *Generator) RecommendedFileName(pkg *common.Package) string {
 	//this is called after Build(), so we can assume that package name,
 	//version, etc. were already validated
-	return fmt.Sprintf("%s-%s.%s.rpm", pkg.Name, fullVersionString(pkg), archMap[pkg.Architecture])
+	return fmt.Sprintf("%s-%s.%s.rpm", pkg.Name, fullVersionString(pkg), pkg.ArchitectureName(archMap))
+}
+
+//compression returns the compression format for the payload (LZMA unless
+//requested otherwise, since it is understood by all current RPM versions).
+func compression(pkg *common.Package) common.Compression {
+	return pkg.Compression.Or(common.CompressionLZMA)
 }
 
 func versionString(pkg *common.Package) string {
@@ -83,24 +143,62 @@ func fullVersionString(pkg *common.Package) string {
 	return fmt.Sprintf("%s-%d", versionString(pkg), pkg.Release)
 }
 
+//appendActions adds the actions that this generator needs in addition to
+//the ones from the package definition.
+func appendActions(pkg *common.Package) {
+	//register alternatives with update-alternatives(1) in the scriptlets
+	pkg.AppendActions(pkg.AlternativeActions()...)
+}
+
+//MaintainerScripts implements the common.Generator interface.
+func (g *Generator) MaintainerScripts(pkg *common.Package) map[string]string {
+	p := pkg.WithBuildActions()
+	appendActions(p)
+	scripts := make(map[string]string)
+	for _, s := range scriptlets(p) {
+		scripts[s.Name] = "#!" + scriptletInterpreter + "\n" + s.Content
+	}
+	return scripts
+}
+
 //Build implements the common.Generator interface.
-func (g *Generator) Build(pkg *common.Package) ([]byte, error) {
-	//assemble CPIO-LZMA payload
+func (g *Generator) Build(pkg *common.Package, w io.Writer) error {
+	appendActions(pkg)
+
+	//license files go into /usr/share/licenses and are marked as %license
+	err := pkg.InsertLicenseFile()
+	if err != nil {
+		return err
+	}
+
+	//assemble compressed CPIO payload
 	payload, err := MakePayload(pkg)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer payload.Close()
 
 	//produce header sections in reverse order (since most of them depend on
 	//what comes after them)
-	headerSection := MakeHeaderSection(pkg, payload)
-	signatureSection := MakeSignatureSection(headerSection, payload)
+	headerSection, err := MakeHeaderSection(pkg, payload)
+	if err != nil {
+		return err
+	}
+	signatureSection, err := MakeSignatureSection(headerSection, payload)
+	if err != nil {
+		return err
+	}
 	lead := NewLead(pkg).ToBinary()
 
 	//combine everything with the correct alignment
 	combined1 := appendAlignedTo8Byte(lead, signatureSection)
 	combined2 := appendAlignedTo8Byte(combined1, headerSection)
-	return append(combined2, payload.Binary...), nil
+	_, err = w.Write(combined2)
+	if err != nil {
+		return err
+	}
+	_, err = payload.WriteTo(w)
+	return err
 }
 
 //According to [LSB, 25.2.2], "A Header structure shall be aligned to an 8 byte
@@ -23,14 +23,17 @@ package rpm
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 )
 
 //Header represents an RPM header structure (as used in the signature section
 //and header section), as defined in [LSB, 25.2.2].
 type Header struct {
-	Records      []*HeaderIndexRecord
-	Data         []byte
-	hasI18NTable bool
+	Records []*HeaderIndexRecord
+	Data    []byte
+	//i18nLocales contains the locales in the I18N table (or nil if it has not
+	//been written yet), see SetI18NLocales.
+	i18nLocales []string
 }
 
 //HeaderIndexRecord represents an index record in a RPM header structure, i.e.
@@ -170,29 +173,90 @@ func (hdr *Header) AddInt32Value(tag uint32, data []int32) {
 	hdr.Data = append(hdr.Data, buf.Bytes()...)
 }
 
+//AddInt64Value adds a value of type RpmInt64Type to this header.
+func (hdr *Header) AddInt64Value(tag uint32, data []int64) {
+	//see near start of AddStringArrayValue() for rationale
+	if len(data) == 0 {
+		return
+	}
+
+	//align to 8 bytes
+	for len(hdr.Data)%8 != 0 {
+		hdr.Data = append(hdr.Data, 0x00)
+	}
+
+	hdr.Records = append(hdr.Records, &HeaderIndexRecord{
+		Tag:    tag,
+		Type:   RpmInt64Type,
+		Offset: uint32(len(hdr.Data)),
+		Count:  uint32(len(data)),
+	})
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, data)
+	hdr.Data = append(hdr.Data, buf.Bytes()...)
+}
+
 //AddStringValue adds a value of type RpmStringType or RpmI18NStringType to
-//this header.
+//this header. I18N strings added in this way have the same value for all
+//locales.
 func (hdr *Header) AddStringValue(tag uint32, data string, i18n bool) {
-	var recordType uint32 = RpmStringType
 	if i18n {
-		recordType = RpmI18NStringType
-		//I18N strings require an I18N table listing the available locales;
-		//initialize that if needed
-		if !hdr.hasI18NTable {
-			hdr.AddStringArrayValue(RpmtagHeaderI18NTable, []string{"C"})
-			hdr.hasI18NTable = true
-		}
+		hdr.AddI18NStringValue(tag, map[string]string{"C": data})
+		return
 	}
 
 	hdr.Records = append(hdr.Records, &HeaderIndexRecord{
 		Tag:    tag,
-		Type:   recordType,
+		Type:   RpmStringType,
 		Offset: uint32(len(hdr.Data)),
 		Count:  1,
 	})
 	hdr.Data = append(append(hdr.Data, []byte(data)...), 0x00)
 }
 
+//SetI18NLocales writes the I18N table, which lists the locales that I18N
+//strings have values for. The default locale "C" is always included as the
+//first entry. This must be called before the first I18N string is added
+//(which writes an I18N table with only the default locale); otherwise an
+//error is returned.
+func (hdr *Header) SetI18NLocales(locales []string) error {
+	if hdr.i18nLocales != nil {
+		return errors.New("I18N table was already written")
+	}
+	hdr.writeI18NTable(locales)
+	return nil
+}
+
+func (hdr *Header) writeI18NTable(locales []string) {
+	hdr.i18nLocales = append([]string{"C"}, locales...)
+	hdr.AddStringArrayValue(RpmtagHeaderI18NTable, hdr.i18nLocales)
+}
+
+//AddI18NStringValue adds a value of type RpmI18NStringType to this header,
+//with one string for each locale in the I18N table. The values map locales to
+//strings; locales that have no value use the value for "C".
+func (hdr *Header) AddI18NStringValue(tag uint32, values map[string]string) {
+	//I18N strings require an I18N table listing the available locales;
+	//initialize that if needed
+	if hdr.i18nLocales == nil {
+		hdr.writeI18NTable(nil)
+	}
+
+	hdr.Records = append(hdr.Records, &HeaderIndexRecord{
+		Tag:    tag,
+		Type:   RpmI18NStringType,
+		Offset: uint32(len(hdr.Data)),
+		Count:  uint32(len(hdr.i18nLocales)),
+	})
+	for _, locale := range hdr.i18nLocales {
+		value, exists := values[locale]
+		if !exists {
+			value = values["C"]
+		}
+		hdr.Data = append(append(hdr.Data, []byte(value)...), 0x00)
+	}
+}
+
 //AddStringArrayValue adds a value of type RpmStringArrayType to this header.
 func (hdr *Header) AddStringArrayValue(tag uint32, data []string) {
 	//skip the tag entirely if it does not contain any data (even if the tag
@@ -217,15 +281,15 @@ func (hdr *Header) AddStringArrayValue(tag uint32, data []string) {
 
 //List of known values for HeaderIndexRecord.Type. [LSB,25.2.2.2.1]
 //
-//Note that we don't support writing all types; null, char and int{8,16,64}
-//are not needed for the tags that we must support.
+//Note that we don't support writing all types; null, char and int8 are not
+//needed for the tags that we must support.
 const (
 	RpmNullType        = 0
 	RpmCharType        = 1
 	RpmInt8Type        = 2
 	RpmInt16Type       = 3
 	RpmInt32Type       = 4
-	RpmInt64Type       = 5 //reserved
+	RpmInt64Type       = 5
 	RpmStringType      = 6
 	RpmBinType         = 7
 	RpmStringArrayType = 8
@@ -275,6 +339,8 @@ const (
 	RpmtagPostInProg        = 1086 //type: STRING
 	RpmtagPreUnProg         = 1087 //type: STRING
 	RpmtagPostUnProg        = 1088 //type: STRING
+	RpmtagPostTrans         = 1152 //type: STRING
+	RpmtagPostTransProg     = 1154 //type: STRING
 	RpmtagOldFileNames      = 1027 //type: STRING_ARRAY
 	RpmtagFileSizes         = 1028 //type: INT32
 	RpmtagFileModes         = 1030 //type: INT16
@@ -303,6 +369,20 @@ const (
 	RpmtagObsoleteName      = 1090 //type: STRING_ARRAY
 	RpmtagObsoleteFlags     = 1114 //type: INT32
 	RpmtagObsoleteVersion   = 1115 //type: STRING_ARRAY
+	RpmtagChangelogTime     = 1080 //type: INT32
+	RpmtagChangelogName     = 1081 //type: STRING_ARRAY
+	RpmtagChangelogText     = 1082 //type: STRING_ARRAY
+)
+
+//INT64 variants of the size tags, which are not in [LSB]. They were added in
+//RPM 4.6 for sizes that do not fit into an INT32, and are only written when
+//needed (see addSizeValues).
+const (
+	RpmsigtagLongSize        = 270  //type: INT64
+	RpmsigtagLongArchiveSize = 271  //type: INT64
+	RpmtagLongArchiveSize    = 271  //type: INT64
+	RpmtagLongFileSizes      = 5008 //type: INT64
+	RpmtagLongSize           = 5009 //type: INT64
 )
 
 //Values for RpmtagFileFlags, see [LSB,25.2.4.3.1].
@@ -22,42 +22,64 @@ package rpm
 
 import (
 	"fmt"
+	"math"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/holocm/holo-build/src/holo-build/common"
 )
 
 //MakeHeaderSection produces the header section of an RPM header.
-func MakeHeaderSection(pkg *common.Package, payload *Payload) []byte {
+func MakeHeaderSection(pkg *common.Package, payload *Payload) ([]byte, error) {
 	h := &Header{}
 
-	addPackageInformationTags(h, pkg)
-	h.AddInt32Value(RpmtagArchiveSize, []int32{int32(payload.UncompressedSize)})
+	err := addPackageInformationTags(h, pkg)
+	if err != nil {
+		return nil, err
+	}
+	addSizeValues(h, RpmtagArchiveSize, RpmtagLongArchiveSize, []int64{payload.UncompressedSize})
 
 	addInstallationTags(h, pkg)
 
 	addFileInformationTags(h, pkg)
 
-	addDependencyInformationTags(h, pkg)
+	addDependencyInformationTags(h, pkg, hasLargeSizes(pkg, payload))
+
+	addChangelogTags(h, pkg)
 
-	return h.ToBinary(RpmtagHeaderImmutable)
+	return h.ToBinary(RpmtagHeaderImmutable), nil
 }
 
 //see [LSB,25.2.4.1]
-func addPackageInformationTags(h *Header, pkg *common.Package) {
+func addPackageInformationTags(h *Header, pkg *common.Package) error {
 	h.AddStringValue(RpmtagName, pkg.Name, false)
 	h.AddStringValue(RpmtagVersion, versionString(pkg), false)
 	h.AddStringValue(RpmtagRelease, fmt.Sprintf("%d", pkg.Release), false)
 
 	//summary == first line of description
-	descSplit := strings.SplitN(pkg.Description, "\n", 2)
-	h.AddStringValue(RpmtagSummary, descSplit[0], true)
-	h.AddStringValue(RpmtagDescription, pkg.Description, true)
-	sizeInBytes := int32(pkg.FSRoot.InstalledSizeInBytes())
-	h.AddInt32Value(RpmtagSize, []int32{sizeInBytes})
-
-	h.AddStringValue(RpmtagLicense, "None", false)
+	locales := pkg.DescriptionLocales()
+	err := h.SetI18NLocales(locales)
+	if err != nil {
+		return err
+	}
+	summaries := map[string]string{"C": strings.SplitN(pkg.Description, "\n", 2)[0]}
+	descriptions := map[string]string{"C": pkg.Description}
+	for _, locale := range locales {
+		desc := pkg.LocalizedDescriptions[locale]
+		summaries[locale] = strings.SplitN(desc, "\n", 2)[0]
+		descriptions[locale] = desc
+	}
+	h.AddI18NStringValue(RpmtagSummary, summaries)
+	h.AddI18NStringValue(RpmtagDescription, descriptions)
+	sizeInBytes := int64(pkg.FSRoot.InstalledSizeInBytes())
+	addSizeValues(h, RpmtagSize, RpmtagLongSize, []int64{sizeInBytes})
+
+	if pkg.License != "" {
+		h.AddStringValue(RpmtagLicense, pkg.License, false)
+	} else {
+		h.AddStringValue(RpmtagLicense, "None", false)
+	}
 
 	if pkg.Author != "" {
 		h.AddStringValue(RpmtagPackager, pkg.Author, false)
@@ -71,29 +93,127 @@ func addPackageInformationTags(h *Header, pkg *common.Package) {
 	h.AddStringValue(RpmtagGroup, "System/Management", true)
 
 	h.AddStringValue(RpmtagOs, "linux", false)
-	h.AddStringValue(RpmtagArch, archMap[pkg.Architecture], false)
+	h.AddStringValue(RpmtagArch, pkg.ArchitectureName(archMap), false)
 
 	h.AddStringValue(RpmtagPayloadFormat, "cpio", false)
-	h.AddStringValue(RpmtagPayloadCompressor, "lzma", false)
-	h.AddStringValue(RpmtagPayloadFlags, "5", false)
+	c := compression(pkg)
+	h.AddStringValue(RpmtagPayloadCompressor, payloadCompressions[c].Compressor, false)
+	h.AddStringValue(RpmtagPayloadFlags, strconv.Itoa(c.Level(pkg.CompressionOptions.Level)), false)
+	return nil
+}
+
+type payloadCompression struct {
+	//Compressor is the value for RPMTAG_PAYLOADCOMPRESSOR.
+	Compressor string
+	//Requirement is the rpmlib() pseudo-dependency that keeps older RPM
+	//versions from installing the package, or nil if all versions can read
+	//this compression format.
+	Requirement *rpmlibPseudoDependency
+}
+
+var payloadCompressions = map[common.Compression]payloadCompression{
+	common.CompressionGzip: {"gzip", nil},
+	common.CompressionLZMA: {"lzma", &rpmlibPseudoDependency{"PayloadIsLzma", "4.4.6-1"}},
+	common.CompressionXZ:   {"xz", &rpmlibPseudoDependency{"PayloadIsXz", "5.2-1"}},
+	common.CompressionZstd: {"zstd", &rpmlibPseudoDependency{"PayloadIsZstd", "5.4.18-1"}},
+}
+
+//The changelog tags are not described in [LSB], but they are well-known from
+//the %changelog section in spec files.
+func addChangelogTags(h *Header, pkg *common.Package) {
+	var (
+		times []int32
+		names []string
+		texts []string
+	)
+	for _, entry := range pkg.Changelog {
+		times = append(times, int32(entry.Date.Unix()))
+		names = append(names, fmt.Sprintf("%s - %s", entry.Author, entry.Version))
+		lines := make([]string, 0, len(entry.Entries))
+		for _, text := range entry.Entries {
+			lines = append(lines, "- "+strings.Replace(text, "\n", "\n  ", -1))
+		}
+		texts = append(texts, strings.Join(lines, "\n"))
+	}
+	h.AddInt32Value(RpmtagChangelogTime, times)
+	h.AddStringArrayValue(RpmtagChangelogName, names)
+	h.AddStringArrayValue(RpmtagChangelogText, texts)
+}
+
+//scriptlet is a script that is stored in the RPM header.
+type scriptlet struct {
+	Name    string //as in spec files, e.g. "%post"
+	Tag     uint32
+	ProgTag uint32
+	Content string
+}
+
+//scriptletInterpreter is the interpreter for all scriptlets (stored in the
+//ProgTag of each scriptlet).
+const scriptletInterpreter = "/bin/sh"
+
+//scriptlets returns the scriptlets for this package.
+func scriptlets(pkg *common.Package) []scriptlet {
+	var result []scriptlet
+	if script := pkg.Script(common.SetupAction); script != "" {
+		result = append(result, scriptlet{"%post", RpmtagPostIn, RpmtagPostInProg, script})
+	}
+	if script := pkg.Script(common.PreCleanupAction); script != "" {
+		//$1 is the number of package instances remaining after the operation,
+		//so 0 means removal (as opposed to upgrade)
+		script = "if [ $1 -eq 0 ]; then\n" + script + "\nfi"
+		result = append(result, scriptlet{"%preun", RpmtagPreUn, RpmtagPreUnProg, script})
+	}
+	//RPM does not distinguish between removal and purging, so purge actions
+	//run after the final removal ($1 is 0 then, see above)
+	script := pkg.Script(common.CleanupAction)
+	if purgeScript := pkg.Script(common.PurgeAction); purgeScript != "" {
+		script = strings.TrimSpace(script + "\nif [ $1 -eq 0 ]; then\n" + purgeScript + "\nfi")
+	}
+	if script != "" {
+		result = append(result, scriptlet{"%postun", RpmtagPostUn, RpmtagPostUnProg, script})
+	}
+	if script := compileConffileMigrations(pkg); script != "" {
+		result = append(result, scriptlet{"%posttrans", RpmtagPostTrans, RpmtagPostTransProg, script})
+	}
+	return result
 }
 
 //see [LSB,25.2.4.2]
 func addInstallationTags(h *Header, pkg *common.Package) {
-	if script := pkg.Script(common.SetupAction); script != "" {
-		h.AddStringValue(RpmtagPostIn, script, false)
-		h.AddStringValue(RpmtagPostInProg, "/bin/sh", false)
+	for _, s := range scriptlets(pkg) {
+		h.AddStringValue(s.Tag, s.Content, false)
+		h.AddStringValue(s.ProgTag, scriptletInterpreter, false)
 	}
-	if script := pkg.Script(common.CleanupAction); script != "" {
-		h.AddStringValue(RpmtagPostUn, script, false)
-		h.AddStringValue(RpmtagPostUnProg, "/bin/sh", false)
+}
+
+//compileConffileMigrations returns the %posttrans script that handles
+//pkg.ConffileMigrations. When a modified configuration file is removed by an
+//upgrade, RPM keeps it as .rpmsave, so move it to the new location (and keep
+//the new default configuration as .rpmnew, like RPM does for conflicting
+//changes). Removed files can stay as .rpmsave.
+//
+//This cannot be done in %post since the old package's files are erased after
+//that. %posttrans does not know the old package version, so the existence of
+//the .rpmsave file is the only condition.
+func compileConffileMigrations(pkg *common.Package) string {
+	var snippets []string
+	for _, m := range pkg.ConffileMigrations {
+		if m.To == "" {
+			continue
+		}
+		snippets = append(snippets, fmt.Sprintf(
+			"if [ -e %s.rpmsave ]; then\nmv -f %s %s.rpmnew\nmv -f %s.rpmsave %s\nfi",
+			m.From, m.To, m.To, m.From, m.To,
+		))
 	}
+	return strings.Join(snippets, "\n")
 }
 
 //see [LSB,25.2.4.3]
 func addFileInformationTags(h *Header, pkg *common.Package) {
 	var (
-		sizes       []int32
+		sizes       []int64
 		modes       []int16
 		rdevs       []int16
 		mtimes      []int32
@@ -155,25 +275,41 @@ func addFileInformationTags(h *Header, pkg *common.Package) {
 			ownerNames = append(ownerNames, idToString(n.Metadata.UID()))
 			groupNames = append(groupNames, idToString(n.Metadata.GID()))
 		case *common.FSRegularFile:
-			sizes = append(sizes, int32(len(n.Content)))
+			sizes = append(sizes, n.Size())
 			md5s = append(md5s, n.MD5Digest())
 			linktos = append(linktos, "")
-			flags = append(flags, RpmfileNoReplace)
+			if path == pkg.LicenseFilePath() {
+				flags = append(flags, RpmfileLicense)
+			} else if pkg.IsConfigFile(path) {
+				//like %config(noreplace) in spec files
+				flags = append(flags, RpmfileConfig|RpmfileNoReplace)
+			} else {
+				flags = append(flags, RpmfileNoReplace)
+			}
 			ownerNames = append(ownerNames, idToString(n.Metadata.UID()))
 			groupNames = append(groupNames, idToString(n.Metadata.GID()))
 		case *common.FSSymlink:
-			sizes = append(sizes, int32(len(n.Target)))
+			sizes = append(sizes, int64(len(n.Target)))
 			md5s = append(md5s, "")
 			linktos = append(linktos, n.Target)
 			flags = append(flags, 0)
 			ownerNames = append(ownerNames, "root")
 			groupNames = append(groupNames, "root")
+		case *common.FSGhostFile:
+			//ghost files are not materialized at install time, so owners and
+			//groups given by name were not postponed into the setup script
+			sizes = append(sizes, 0)
+			md5s = append(md5s, "")
+			linktos = append(linktos, "")
+			flags = append(flags, RpmfileGhost)
+			ownerNames = append(ownerNames, refToString(n.Metadata.Owner))
+			groupNames = append(groupNames, refToString(n.Metadata.Group))
 		}
 
 		return nil
 	})
 
-	h.AddInt32Value(RpmtagFileSizes, sizes)
+	addSizeValues(h, RpmtagFileSizes, RpmtagLongFileSizes, sizes)
 	h.AddInt16Value(RpmtagFileModes, modes)
 	h.AddInt16Value(RpmtagFileRdevs, rdevs)
 	h.AddInt32Value(RpmtagFileMtimes, mtimes)
@@ -211,15 +347,79 @@ func idToString(id uint32) string {
 	return fmt.Sprintf("%d", id)
 }
 
+func refToString(ref *common.IntOrString) string {
+	if ref == nil {
+		return "root"
+	}
+	if ref.Str != "" {
+		return ref.Str
+	}
+	return idToString(ref.Int)
+}
+
 //see [LSB,25.2.4.4]
-func addDependencyInformationTags(h *Header, pkg *common.Package) {
-	serializeRelations(h, pkg.Requires,
+func addDependencyInformationTags(h *Header, pkg *common.Package, largeSizes bool) {
+	//Requires and PreDepends are both serialized into the requirements list;
+	//some of them get additional flags to tell RPM that they are needed by
+	//the package's scripts (this affects the order of installation)
+	var (
+		requires   []common.PackageRelation
+		extraFlags []int32
+	)
+
+	//the setup and cleanup scripts will call `holo apply`, so the Holo plugins
+	//need to be there when those scripts run
+	isHoloPlugin := make(map[string]bool)
+	for _, pluginID := range pkg.HoloPluginIDs() {
+		isHoloPlugin["holo-"+pluginID] = true
+	}
+	for _, rel := range pkg.Requires {
+		requires = append(requires, rel)
+		if isHoloPlugin[rel.RelatedPackage] {
+			extraFlags = append(extraFlags, RpmsenseScriptPost|RpmsenseScriptPostUn)
+		} else {
+			extraFlags = append(extraFlags, 0)
+		}
+	}
+
+	//RPM does not have pre-dependencies in the Debian sense; the closest
+	//thing is to require something for the %pre and %post scripts
+	for _, rel := range pkg.PreDepends {
+		requires = append(requires, rel)
+		extraFlags = append(extraFlags, RpmsenseScriptPre|RpmsenseScriptPost)
+	}
+
+	//the scriptlets for alternatives need update-alternatives(1) (which is
+	//provided under this path by both Fedora's chkconfig and openSUSE's
+	//update-alternatives package)
+	if len(pkg.Alternatives) > 0 {
+		requires = append(requires, common.PackageRelation{RelatedPackage: "/usr/sbin/update-alternatives"})
+		extraFlags = append(extraFlags, RpmsenseScriptPost|RpmsenseScriptPreUn)
+	}
+
+	//for the Requires list, we need to add pseudo-dependencies to describe the
+	//structure of our package (because apparently a custom key-value database
+	//wasn't enough, so they built a second key-value database inside the
+	//requirements array -- BRILLIANT!)
+	for _, dep := range rpmlibPseudoDependencies(pkg, requires, largeSizes) {
+		requires = append(requires, common.PackageRelation{
+			RelatedPackage: "rpmlib(" + dep.Name + ")",
+			Constraints: []common.VersionConstraint{
+				common.VersionConstraint{Relation: "rpmlib", Version: dep.Version},
+			},
+		})
+	}
+
+	//RPM does not know "breaks", but "conflicts" is close enough
+	conflicts := append(append([]common.PackageRelation{}, pkg.Conflicts...), pkg.Breaks...)
+
+	serializeRelations(h, requires, extraFlags,
 		RpmtagRequireName, RpmtagRequireFlags, RpmtagRequireVersion)
-	serializeRelations(h, pkg.Provides,
+	serializeRelations(h, pkg.Provides, nil,
 		RpmtagProvideName, RpmtagProvideFlags, RpmtagProvideVersion)
-	serializeRelations(h, pkg.Conflicts,
+	serializeRelations(h, conflicts, nil,
 		RpmtagConflictName, RpmtagConflictFlags, RpmtagConflictVersion)
-	serializeRelations(h, pkg.Replaces,
+	serializeRelations(h, pkg.Replaces, nil,
 		RpmtagObsoleteName, RpmtagObsoleteFlags, RpmtagObsoleteVersion)
 }
 
@@ -228,21 +428,71 @@ type rpmlibPseudoDependency struct {
 	Version string
 }
 
-var rpmlibPseudoDependencies = []rpmlibPseudoDependency{
-	//indicate that RPMTAG_PROVIDENAME and RPMTAG_OBSOLETENAME may have a
-	//version associated with them (as if the presence of
-	//RPMTAG_PROVIDEVERSION and RPMTAG_OBSOLETEVERSION is not enough)
-	rpmlibPseudoDependency{"VersionedDependencies", "3.0.3-1"},
-	//indicate that filenames in the payload are represented in the
-	//RPMTAG_DIRINDEXES, RPMTAG_DIRNAME and RPMTAG_BASENAMES indexes
-	//(again, as if the presence of these tags wasn't evidence enough)
-	rpmlibPseudoDependency{"CompressedFileNames", "3.0.4-1"},
+//rpmlibPseudoDependencies returns the pseudo-dependencies that describe the
+//structure of the package, for the given list of requirements. If
+//`largeSizes` is true, the header uses the INT64 size tags.
+func rpmlibPseudoDependencies(pkg *common.Package, requires []common.PackageRelation, largeSizes bool) []rpmlibPseudoDependency {
+	deps := []rpmlibPseudoDependency{
+		//indicate that RPMTAG_PROVIDENAME and RPMTAG_OBSOLETENAME may have a
+		//version associated with them (as if the presence of
+		//RPMTAG_PROVIDEVERSION and RPMTAG_OBSOLETEVERSION is not enough)
+		rpmlibPseudoDependency{"VersionedDependencies", "3.0.3-1"},
+		//indicate that filenames in the payload are represented in the
+		//RPMTAG_DIRINDEXES, RPMTAG_DIRNAME and RPMTAG_BASENAMES indexes
+		//(again, as if the presence of these tags wasn't evidence enough)
+		rpmlibPseudoDependency{"CompressedFileNames", "3.0.4-1"},
+	}
+
 	//title says it all; apparently RPM devs haven't got the memo that you can
 	//easily identify compression formats by the first few bytes
-	rpmlibPseudoDependency{"PayloadIsLzma", "4.4.6-1"},
+	if dep := payloadCompressions[compression(pkg)].Requirement; dep != nil {
+		deps = append(deps, *dep)
+	}
+
 	//path names in the CPIO payload start with "./" because apparently you
 	//cannot read that from the payload itself
-	rpmlibPseudoDependency{"PayloadFilesHavePrefix", "4.0-1"},
+	deps = append(deps, rpmlibPseudoDependency{"PayloadFilesHavePrefix", "4.0-1"})
+
+	//this one is only added when the package actually contains rich
+	//dependencies like "(foo or bar)", so that older RPM versions can still
+	//install packages without them
+	for _, rel := range requires {
+		if rel.HasAlternatives() {
+			deps = append(deps, rpmlibPseudoDependency{"RichDependencies", "4.12.0-1"})
+			break
+		}
+	}
+
+	//RPM versions that don't know this one would read the INT32 size tags,
+	//which are missing when the INT64 ones are used
+	if largeSizes {
+		deps = append(deps, rpmlibPseudoDependency{"LargeFiles", "4.12.0-1"})
+	}
+
+	return deps
+}
+
+//addSizeValues adds the given sizes to the header. The INT32 tag is used if
+//all sizes fit into it (which is what older RPM versions understand);
+//otherwise the INT64 tag is used.
+func addSizeValues(h *Header, tag, longTag uint32, sizes []int64) {
+	values := make([]int32, 0, len(sizes))
+	for _, size := range sizes {
+		if size > math.MaxInt32 {
+			h.AddInt64Value(longTag, sizes)
+			return
+		}
+		values = append(values, int32(size))
+	}
+	h.AddInt32Value(tag, values)
+}
+
+//hasLargeSizes returns whether any of the sizes in the header section need
+//INT64 size tags. (Each file is contained in the payload, so the payload is
+//always larger than any single file.)
+func hasLargeSizes(pkg *common.Package, payload *Payload) bool {
+	return payload.UncompressedSize > math.MaxInt32 ||
+		int64(pkg.FSRoot.InstalledSizeInBytes()) > math.MaxInt32
 }
 
 var flagsForConstraintRelation = map[string]int32{
@@ -254,39 +504,36 @@ var flagsForConstraintRelation = map[string]int32{
 	"rpmlib": RpmsenseRpmlib | RpmsenseLess | RpmsenseEqual,
 }
 
-func serializeRelations(h *Header, rels []common.PackageRelation, namesTag, flagsTag, versionsTag uint32) {
-	//for the Requires list, we need to add pseudo-dependencies to describe the
-	//structure of our package (because apparently a custom key-value database
-	//wasn't enough, so they built a second key-value database inside the
-	//requirements array -- BRILLIANT!)
-	if namesTag == RpmtagRequireName {
-		for _, dep := range rpmlibPseudoDependencies {
-			rels = append(rels, common.PackageRelation{
-				RelatedPackage: "rpmlib(" + dep.Name + ")",
-				Constraints: []common.VersionConstraint{
-					common.VersionConstraint{Relation: "rpmlib", Version: dep.Version},
-				},
-			})
-		}
-	}
-
+//The extraFlags, if not nil, contain additional flags for each relation in
+//rels (e.g. RpmsenseScriptPost).
+func serializeRelations(h *Header, rels []common.PackageRelation, extraFlags []int32, namesTag, flagsTag, versionsTag uint32) {
 	//serialize relations into RPM's bizarre multi-array format
 	var (
 		names    []string
 		flags    []int32
 		versions []string
 	)
-	for _, rel := range rels {
-		if len(rel.Constraints) == 0 {
+	for idx, rel := range rels {
+		var extra int32
+		if idx < len(extraFlags) {
+			extra = extraFlags[idx]
+		}
+
+		if rel.HasAlternatives() {
+			//case 0: alternatives -> generate one rich dependency like "(foo >= 2.0 or bar)"
+			names = append(names, compileRichDependency(rel.Alternatives))
+			flags = append(flags, RpmsenseAny|extra)
+			versions = append(versions, "")
+		} else if len(rel.Constraints) == 0 {
 			//case 1: no version constraints -> generate one relation for the RelatedPackage
 			names = append(names, rel.RelatedPackage)
-			flags = append(flags, RpmsenseAny)
+			flags = append(flags, RpmsenseAny|extra)
 			versions = append(versions, "")
 		} else {
 			//case 2: no version constraints -> generate one relation per constraint
 			for _, cons := range rel.Constraints {
 				names = append(names, rel.RelatedPackage)
-				flags = append(flags, flagsForConstraintRelation[cons.Relation])
+				flags = append(flags, flagsForConstraintRelation[cons.Relation]|extra)
 				versions = append(versions, cons.Version)
 			}
 		}
@@ -296,3 +543,26 @@ func serializeRelations(h *Header, rels []common.PackageRelation, namesTag, flag
 	h.AddInt32Value(flagsTag, flags)
 	h.AddStringArrayValue(versionsTag, versions)
 }
+
+//Compiles alternatives into the syntax for rich dependencies, e.g.
+//"(foo >= 2.0 or bar)". Multiple constraints on the same alternative are
+//combined into a nested expression like "(foo >= 2.0 with foo < 3.0)".
+func compileRichDependency(alternatives []common.PackageRelation) string {
+	terms := make([]string, 0, len(alternatives))
+	for _, alt := range alternatives {
+		if len(alt.Constraints) == 0 {
+			terms = append(terms, alt.RelatedPackage)
+			continue
+		}
+		constraints := make([]string, 0, len(alt.Constraints))
+		for _, cons := range alt.Constraints {
+			constraints = append(constraints, fmt.Sprintf("%s %s %s", alt.RelatedPackage, cons.Relation, cons.Version))
+		}
+		if len(constraints) == 1 {
+			terms = append(terms, constraints[0])
+		} else {
+			terms = append(terms, "("+strings.Join(constraints, " with ")+")")
+		}
+	}
+	return "(" + strings.Join(terms, " or ") + ")"
+}
@@ -0,0 +1,92 @@
+/*******************************************************************************
+*
+* Copyright 2018 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package rpm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/holocm/holo-build/src/holo-build/common"
+)
+
+//Packages with sizes above 2 GiB are too large to be built in the test suite,
+//so the choice of size tags is checked here.
+func TestAddSizeValues(t *testing.T) {
+	testCases := []struct {
+		Sizes        []int64
+		ExpectedTag  uint32
+		ExpectedType uint32
+		ExpectedData interface{}
+	}{
+		{[]int64{42, 4096}, RpmtagFileSizes, RpmInt32Type, []int32{42, 4096}},
+		{[]int64{42, math.MaxInt32}, RpmtagFileSizes, RpmInt32Type, []int32{42, math.MaxInt32}},
+		{[]int64{42, math.MaxInt32 + 1}, RpmtagLongFileSizes, RpmInt64Type, []int64{42, math.MaxInt32 + 1}},
+		{[]int64{42, 5 << 30}, RpmtagLongFileSizes, RpmInt64Type, []int64{42, 5 << 30}},
+	}
+
+	for _, tc := range testCases {
+		//start with an odd offset to check the alignment
+		h := &Header{Data: []byte{0x00}}
+		addSizeValues(h, RpmtagFileSizes, RpmtagLongFileSizes, tc.Sizes)
+
+		if len(h.Records) != 1 {
+			t.Fatalf("sizes %v: expected 1 record, got %d", tc.Sizes, len(h.Records))
+		}
+		r := h.Records[0]
+		if r.Tag != tc.ExpectedTag || r.Type != tc.ExpectedType || r.Count != uint32(len(tc.Sizes)) {
+			t.Errorf("sizes %v: expected tag %d, type %d, count %d; got tag %d, type %d, count %d",
+				tc.Sizes, tc.ExpectedTag, tc.ExpectedType, len(tc.Sizes), r.Tag, r.Type, r.Count)
+		}
+
+		var expected bytes.Buffer
+		binary.Write(&expected, binary.BigEndian, tc.ExpectedData)
+		if r.Offset%uint32(expected.Len()/len(tc.Sizes)) != 0 {
+			t.Errorf("sizes %v: offset %d is not aligned", tc.Sizes, r.Offset)
+		}
+		if !bytes.Equal(h.Data[r.Offset:], expected.Bytes()) {
+			t.Errorf("sizes %v: expected data %x, got %x", tc.Sizes, expected.Bytes(), h.Data[r.Offset:])
+		}
+	}
+}
+
+func TestLargeSizesRequireLargeFiles(t *testing.T) {
+	pkg := &common.Package{FSRoot: common.NewFSDirectory()}
+
+	for _, size := range []int64{1024, math.MaxInt32, math.MaxInt32 + 1, 5 << 30} {
+		payload := &Payload{UncompressedSize: size}
+		largeSizes := hasLargeSizes(pkg, payload)
+		if largeSizes != (size > math.MaxInt32) {
+			t.Errorf("payload size %d: expected large sizes = %t", size, !largeSizes)
+		}
+
+		hasLargeFiles := false
+		for _, dep := range rpmlibPseudoDependencies(pkg, nil, largeSizes) {
+			if dep.Name == "LargeFiles" {
+				hasLargeFiles = true
+			}
+		}
+		if hasLargeFiles != largeSizes {
+			t.Errorf("payload size %d: expected rpmlib(LargeFiles) = %t", size, largeSizes)
+		}
+	}
+}
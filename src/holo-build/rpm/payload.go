@@ -21,19 +21,36 @@
 package rpm
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/binary"
+	"io"
 	"os"
-	"os/exec"
 
 	"github.com/holocm/holo-build/src/holo-build/common"
 )
 
-//Payload represents the compressed CPIO payload of the package.
+//Payload represents the compressed CPIO payload of the package. Since the
+//payload can be very large, it is held in a temporary file instead of in
+//memory. The payload must be closed when it is no longer needed.
 type Payload struct {
-	Binary           []byte
-	CompressedSize   uint32
-	UncompressedSize uint32
+	File             *os.File
+	CompressedSize   int64
+	UncompressedSize int64
+}
+
+//WriteTo implements the io.WriterTo interface. It copies the compressed
+//payload into the given writer.
+func (p *Payload) WriteTo(w io.Writer) (int64, error) {
+	_, err := p.File.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	return io.CopyN(w, p.File, p.CompressedSize)
+}
+
+//Close deletes the temporary file holding the payload.
+func (p *Payload) Close() error {
+	return p.File.Close()
 }
 
 type cpioHeader struct {
@@ -53,9 +70,19 @@ type cpioHeader struct {
 	Checksum         [8]byte
 }
 
+//maxFileSize is the largest file size that fits into a CPIO header.
+const maxFileSize = 1<<32 - 1
+
 //MakePayload generates the Payload for the given package.
 func MakePayload(pkg *common.Package) (*Payload, error) {
-	var buf bytes.Buffer
+	//write the uncompressed CPIO archive into a temporary file first, since
+	//the LZMA compression needs to know the uncompressed size in advance
+	cpioFile, err := common.NewSpoolFile()
+	if err != nil {
+		return nil, err
+	}
+	defer cpioFile.Close()
+	buf := &cpioWriter{w: bufio.NewWriter(cpioFile)}
 	inodeNumber := uint32(0)
 
 	//some fixed values that we can reuse
@@ -65,7 +92,7 @@ func MakePayload(pkg *common.Package) (*Payload, error) {
 
 	//assemble the CPIO archive
 	//(NOTE: This traversal works in the same way as the one in addFileInformationTags.)
-	pkg.WalkFSWithAbsolutePaths(func(path string, node common.FSNode) error {
+	err = pkg.WalkFSWithAbsolutePaths(func(path string, node common.FSNode) error {
 		//skip implicitly created directories (as rpmbuild-constructed CPIO
 		//archives apparently do)
 		if n, ok := node.(*common.FSDirectory); ok {
@@ -74,7 +101,13 @@ func MakePayload(pkg *common.Package) (*Payload, error) {
 			}
 		}
 
-		inodeNumber++                            //make up inode numbers in the same way as rpmbuild does
+		inodeNumber++ //make up inode numbers in the same way as rpmbuild does
+
+		//ghost files are listed in the header, but not shipped in the payload
+		if _, ok := node.(*common.FSGhostFile); ok {
+			return nil
+		}
+
 		name := append([]byte("."+path), '\000') //must be NUL-terminated!
 
 		header := cpioHeader{
@@ -93,33 +126,39 @@ func MakePayload(pkg *common.Package) (*Payload, error) {
 			Checksum:  cpioZero,
 		}
 
-		var data []byte
-
 		switch n := node.(type) {
 		case *common.FSDirectory:
 			header.UID = cpioFormatInt(n.Metadata.UID())
 			header.GID = cpioFormatInt(n.Metadata.GID())
 			header.FileSize = cpioZero
+			buf.WriteHeader(&header, name)
 		case *common.FSRegularFile:
 			header.UID = cpioFormatInt(n.Metadata.UID())
 			header.GID = cpioFormatInt(n.Metadata.GID())
-			data = []byte(n.Content)
+			header.FileSize = cpioFormatInt(uint32(n.Size())) //checked in Validate()
+			buf.WriteHeader(&header, name)
+			err := n.WriteContentsTo(buf)
+			if err != nil {
+				return err
+			}
+			buf.Pad()
 		case *common.FSSymlink:
 			header.UID = cpioZero
 			header.GID = cpioZero
-			data = []byte(n.Target)
+			header.FileSize = cpioFormatInt(uint32(len(n.Target)))
+			buf.WriteHeader(&header, name)
+			buf.WriteData([]byte(n.Target))
 		}
-		header.FileSize = cpioFormatInt(uint32(len(data)))
-		binary.Write(&buf, binary.BigEndian, &header)
-		cpioWriteData(&buf, name)
-		cpioWriteData(&buf, data)
 
-		return nil
+		return buf.err
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	//write trailer record to indicate the end of the CPIO archive
 	trailerName := []byte("TRAILER!!!\000")
-	binary.Write(&buf, binary.BigEndian, &cpioHeader{
+	buf.WriteHeader(&cpioHeader{
 		Magic:            cpioMagic,
 		InodeNumber:      cpioZero,
 		Mode:             cpioZero,
@@ -134,21 +173,57 @@ func MakePayload(pkg *common.Package) (*Payload, error) {
 		RdevMinor:        cpioZero,
 		NameSize:         cpioFormatInt(uint32(len(trailerName))),
 		Checksum:         cpioZero,
-	})
-	cpioWriteData(&buf, trailerName)
+	}, trailerName)
+	if buf.err == nil {
+		buf.err = buf.w.Flush()
+	}
+	if buf.err != nil {
+		return nil, buf.err
+	}
 
-	//compress the archive with LZMA
-	uncompressed := buf.Bytes()
-	cmd := exec.Command("xz", "--format=lzma", "--compress")
-	cmd.Stdin = bytes.NewReader(uncompressed)
-	cmd.Stderr = os.Stderr
-	compressed, err := cmd.Output()
+	//compress the archive into another temporary file
+	_, err = cpioFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	payloadFile, err := common.NewSpoolFile()
+	if err != nil {
+		return nil, err
+	}
+	compressedSize, err := compressPayload(pkg, payloadFile, cpioFile, buf.offset)
+	if err != nil {
+		payloadFile.Close()
+		return nil, err
+	}
 
 	return &Payload{
-		Binary:           compressed,
-		CompressedSize:   uint32(len(compressed)),
-		UncompressedSize: uint32(len(uncompressed)),
-	}, err
+		File:             payloadFile,
+		CompressedSize:   compressedSize,
+		UncompressedSize: buf.offset,
+	}, nil
+}
+
+//compressPayload compresses the uncompressed CPIO archive into the given
+//file, and returns the compressed size.
+func compressPayload(pkg *common.Package, out *os.File, in io.Reader, size int64) (int64, error) {
+	bufOut := bufio.NewWriter(out)
+	w, err := compression(pkg).NewWriter(bufOut, size, pkg.CompressionOptions)
+	if err != nil {
+		return 0, err
+	}
+	_, err = io.CopyN(w, in, size)
+	if err != nil {
+		return 0, err
+	}
+	err = w.Close()
+	if err != nil {
+		return 0, err
+	}
+	err = bufOut.Flush()
+	if err != nil {
+		return 0, err
+	}
+	return out.Seek(0, io.SeekCurrent)
 }
 
 var hexDigits = []byte("0123456789ABCDEF")
@@ -162,12 +237,47 @@ func cpioFormatInt(value uint32) [8]byte {
 	return str
 }
 
-func cpioWriteData(buf *bytes.Buffer, data []byte) {
-	buf.Write(data)
-	//file names, contents, link targets need to end with padding to 4-byte
-	//alignment (note that we cannot compute the padding size from len(data)
-	//since the stream is not necessarily 4-byte-aligned before data)
-	for buf.Len()%4 != 0 {
-		buf.Write([]byte{'\000'})
+//cpioWriter writes a CPIO archive, and keeps track of the offset in the
+//archive for the padding. The first error is stored in `err`; all writes after
+//an error are skipped.
+type cpioWriter struct {
+	w      *bufio.Writer
+	offset int64
+	err    error
+}
+
+//Write implements the io.Writer interface.
+func (cw *cpioWriter) Write(data []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(data)
+	cw.offset += int64(n)
+	cw.err = err
+	return n, err
+}
+
+//WriteHeader writes a header record and the file name following it.
+func (cw *cpioWriter) WriteHeader(header *cpioHeader, name []byte) {
+	if cw.err == nil {
+		cw.err = binary.Write(cw, binary.BigEndian, header)
+	}
+	cw.WriteData(name)
+}
+
+//WriteData writes file names, contents or link targets, and the padding
+//following them.
+func (cw *cpioWriter) WriteData(data []byte) {
+	cw.Write(data)
+	cw.Pad()
+}
+
+//Pad writes padding to 4-byte alignment, which must follow file names,
+//contents and link targets. (Note that we cannot compute the padding size
+//from len(data) since the stream is not necessarily 4-byte-aligned before
+//data.)
+func (cw *cpioWriter) Pad() {
+	for cw.offset%4 != 0 {
+		cw.Write([]byte{'\000'})
 	}
 }
@@ -27,7 +27,7 @@ import (
 )
 
 //MakeSignatureSection produces the signature section of an RPM header.
-func MakeSignatureSection(headerSection []byte, payload *Payload) []byte {
+func MakeSignatureSection(headerSection []byte, payload *Payload) ([]byte, error) {
 	h := &Header{}
 
 	//NOTE that some fields validate both header+payload, some only the
@@ -35,11 +35,11 @@ func MakeSignatureSection(headerSection []byte, payload *Payload) []byte {
 	//specification, no matter how insane. [LSB, 25.2.3]
 
 	//size information
-	h.AddInt32Value(RpmsigtagSize, []int32{
-		int32(uint32(len(headerSection)) + payload.CompressedSize),
+	addSizeValues(h, RpmsigtagSize, RpmsigtagLongSize, []int64{
+		int64(len(headerSection)) + payload.CompressedSize,
 	})
-	h.AddInt32Value(RpmsigtagPayloadSize, []int32{
-		int32(payload.UncompressedSize),
+	addSizeValues(h, RpmsigtagPayloadSize, RpmsigtagLongArchiveSize, []int64{
+		payload.UncompressedSize,
 	})
 
 	//SHA1 digest of header section
@@ -51,9 +51,12 @@ func MakeSignatureSection(headerSection []byte, payload *Payload) []byte {
 	//MD5 digest of header + payload section
 	md5digest := md5.New()
 	md5digest.Write(headerSection)
-	md5digest.Write(payload.Binary)
+	_, err := payload.WriteTo(md5digest)
+	if err != nil {
+		return nil, err
+	}
 	md5sum := md5digest.Sum(nil)
 	h.AddBinaryValue(RpmsigtagMD5, md5sum)
 
-	return h.ToBinary(RpmtagHeaderSignatures)
+	return h.ToBinary(RpmtagHeaderSignatures), nil
 }
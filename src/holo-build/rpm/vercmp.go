@@ -0,0 +1,198 @@
+/*******************************************************************************
+*
+* Copyright 2018 Stefan Majewsky <majewsky@gmx.net>
+*
+* This file is part of Holo.
+*
+* Holo is free software: you can redistribute it and/or modify it under the
+* terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* Holo is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* Holo. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package rpm
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/holocm/holo-build/src/holo-build/common"
+)
+
+//CompareVersions implements the common.VersionComparer interface.
+func (g *Generator) CompareVersions(a, b string) int {
+	return CompareVersions(a, b)
+}
+
+//FullVersionString implements the common.VersionComparer interface.
+func (g *Generator) FullVersionString(pkg *common.Package) string {
+	return fullVersionString(pkg)
+}
+
+//CompareVersions compares two RPM version strings of the form
+//"[epoch:]version[-release]" in the same way as rpm(8), and returns -1, 0 or
+//1. Like in RPM dependency resolution, the release is only compared if both
+//versions have one.
+func CompareVersions(a, b string) int {
+	epochA, versionA, releaseA := splitVersion(a)
+	epochB, versionB, releaseB := splitVersion(b)
+
+	switch {
+	case epochA < epochB:
+		return -1
+	case epochA > epochB:
+		return 1
+	}
+	if result := compareVersionPart(versionA, versionB); result != 0 {
+		return result
+	}
+	if releaseA == "" || releaseB == "" {
+		return 0
+	}
+	return compareVersionPart(releaseA, releaseB)
+}
+
+//splitVersion splits a version string into epoch, version and release.
+func splitVersion(version string) (epoch uint64, ver, release string) {
+	if idx := strings.Index(version, ":"); idx >= 0 {
+		//an invalid epoch is treated as 0 (the version format is checked by
+		//Validate() anyway)
+		epoch, _ = strconv.ParseUint(version[:idx], 10, 32)
+		version = version[idx+1:]
+	}
+	if idx := strings.LastIndex(version, "-"); idx >= 0 {
+		return epoch, version[:idx], version[idx+1:]
+	}
+	return epoch, version, ""
+}
+
+//compareVersionPart compares versions or releases like rpmvercmp() in librpm,
+//including the special handling of "~" (sorts before everything) and "^"
+//(sorts after the end of the string, but before everything else).
+func compareVersionPart(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for i < len(a) && !isAlnum(a[i]) && a[i] != '~' && a[i] != '^' {
+			i++
+		}
+		for j < len(b) && !isAlnum(b[j]) && b[j] != '~' && b[j] != '^' {
+			j++
+		}
+
+		//handle the tilde separator
+		if (i < len(a) && a[i] == '~') || (j < len(b) && b[j] == '~') {
+			if i >= len(a) || a[i] != '~' {
+				return 1
+			}
+			if j >= len(b) || b[j] != '~' {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+
+		//handle the caret separator
+		if (i < len(a) && a[i] == '^') || (j < len(b) && b[j] == '^') {
+			if i >= len(a) {
+				return -1
+			}
+			if j >= len(b) {
+				return 1
+			}
+			if a[i] != '^' {
+				return 1
+			}
+			if b[j] != '^' {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+
+		if i >= len(a) || j >= len(b) {
+			break
+		}
+
+		//grab the next segment of the same type in both strings
+		startA, startB := i, j
+		isNum := isDigit(a[i])
+		if isNum {
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+		} else {
+			for i < len(a) && isAlpha(a[i]) {
+				i++
+			}
+			for j < len(b) && isAlpha(b[j]) {
+				j++
+			}
+		}
+
+		//segments of different types: numeric segments are newer than alpha segments
+		if startB == j {
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+
+		if result := compareSegments(a[startA:i], b[startB:j], isNum); result != 0 {
+			return result
+		}
+	}
+
+	switch {
+	case i >= len(a) && j >= len(b):
+		return 0
+	case i < len(a):
+		return 1
+	default:
+		return -1
+	}
+}
+
+//compareSegments compares two segments of the same type (both numeric or both
+//alphabetic).
+func compareSegments(a, b string, isNum bool) int {
+	if isNum {
+		a = strings.TrimLeft(a, "0")
+		b = strings.TrimLeft(b, "0")
+		switch {
+		case len(a) < len(b):
+			return -1
+		case len(a) > len(b):
+			return 1
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || isAlpha(c)
+}
@@ -44,3 +44,4 @@ echo checking forceful rebuild without error >&2
 dd if=/dev/zero of=package.deb bs=4K count=1 status=none
 ${HOLO_BUILD} --force --format=debian -o package.deb ${INPUT_TOML}
 file package.deb
+find . -name '.package.deb.*' # should output nothing (no leftover temporary files)
@@ -0,0 +1,25 @@
+#!/bin/sh
+
+# check that --pacman-alternatives=first resolves alternative requirements to
+# their first alternative, and that invalid values are rejected
+
+cat > input.toml <<EOT
+[package]
+name = "package"
+version = "1.0"
+requires = ["openssh>=7.0 | dropbear", "cron | cronie"]
+EOT
+
+echo checking default behavior
+echo checking default behavior >&2
+${HOLO_BUILD} --format=pacman -o - input.toml | ${DUMP_PACKAGE} | grep depend
+
+echo checking --pacman-alternatives=first
+echo checking --pacman-alternatives=first >&2
+${HOLO_BUILD} --format=pacman --pacman-alternatives=first -o - input.toml | ${DUMP_PACKAGE} | grep depend
+
+echo checking invalid value
+echo checking invalid value >&2
+${HOLO_BUILD} --format=pacman --pacman-alternatives=random input.toml
+
+rm -f input.toml
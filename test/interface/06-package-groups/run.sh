@@ -0,0 +1,65 @@
+#!/bin/sh
+
+# check that --package-groups reads package groups from a TOML file, a pacman
+# sync database or a directory of sync databases, that this also works for
+# generators other than pacman, and that group members are renamed by relation
+# maps and validated like all other related packages
+
+cat > input.toml <<EOT
+[package]
+name = "package"
+version = "1.0"
+author = "Holo Build <holo.build@example.org>"
+requires = ["group:base", "except:bar"]
+EOT
+
+cat > groups.toml <<EOT
+base = ["foo", "bar", "baz"]
+broken = ["Foo_Bar"]
+EOT
+
+cat > relations.toml <<EOT
+[foo]
+debian = "foo-utils"
+EOT
+
+# build a minimal sync database (one "desc" file per package)
+mkdir -p syncdb/foo-1.0-1 syncdb/bar-1.0-1 syncdb/qux-1.0-1 repos
+printf '%%NAME%%\nfoo\n\n%%GROUPS%%\nbase\nbase-devel\n\n' > syncdb/foo-1.0-1/desc
+printf '%%NAME%%\nbar\n\n%%GROUPS%%\nbase\n\n' > syncdb/bar-1.0-1/desc
+printf '%%NAME%%\nqux\n\n%%VERSION%%\n1.0-1\n\n' > syncdb/qux-1.0-1/desc
+tar -C syncdb -czf core.db foo-1.0-1 bar-1.0-1 qux-1.0-1
+tar -C syncdb -cf repos/extra.db qux-1.0-1
+cp core.db repos/core.db
+
+echo checking TOML file with Debian
+echo checking TOML file with Debian >&2
+${HOLO_BUILD} --format=debian --package-groups=groups.toml -o - input.toml | ${DUMP_PACKAGE} | grep Depends
+
+echo checking sync database with RPM
+echo checking sync database with RPM >&2
+${HOLO_BUILD} --format=rpm --package-groups=core.db -o - input.toml | ${DUMP_PACKAGE} | grep -A3 REQUIRENAME
+
+echo checking directory of sync databases with pacman
+echo checking directory of sync databases with pacman >&2
+${HOLO_BUILD} --format=pacman --package-groups=repos -o - input.toml | ${DUMP_PACKAGE} | grep depend
+
+echo checking relation map with group members
+echo checking relation map with group members >&2
+${HOLO_BUILD} --format=debian --package-groups=groups.toml --relation-map=relations.toml -o - input.toml | ${DUMP_PACKAGE} | grep Depends
+
+echo checking invalid group members
+echo checking invalid group members >&2
+sed 's/group:base/group:broken/' input.toml > broken.toml
+${HOLO_BUILD} --format=debian --package-groups=groups.toml -o - broken.toml
+
+echo checking unknown group
+echo checking unknown group >&2
+sed -i 's/group:base/group:unknown/' input.toml
+${HOLO_BUILD} --format=debian --package-groups=groups.toml -o - input.toml
+
+echo checking missing source
+echo checking missing source >&2
+${HOLO_BUILD} --format=debian --package-groups=missing.db -o - input.toml
+
+rm -rf input.toml broken.toml groups.toml relations.toml core.db syncdb repos
@@ -0,0 +1,51 @@
+#!/bin/sh
+
+# check that --relation-map overrides package.relationMap, and that
+# --strict-relation-map rejects package names without a mapping
+
+cat > input.toml <<EOT
+[package]
+name = "package"
+version = "1.0"
+author = "Holo Build <holo.build@example.org>"
+relationMap = "map1.toml"
+requires = ["openssh", "cronie"]
+EOT
+
+cat > map1.toml <<EOT
+[openssh]
+debian = "openssh-server"
+EOT
+
+cat > map2.toml <<EOT
+[openssh]
+debian = "openssh-client"
+pacman = "openssh"
+[cronie]
+debian = "cron"
+EOT
+
+cat > broken.toml <<EOT
+[openssh]
+gentoo = "net-misc/openssh"
+EOT
+
+echo checking relation map from package definition
+echo checking relation map from package definition >&2
+${HOLO_BUILD} --format=debian -o - input.toml | ${DUMP_PACKAGE} | grep Depends
+
+echo checking --relation-map
+echo checking --relation-map >&2
+${HOLO_BUILD} --format=debian --relation-map=map2.toml -o - input.toml | ${DUMP_PACKAGE} | grep Depends
+
+echo checking --strict-relation-map
+echo checking --strict-relation-map >&2
+${HOLO_BUILD} --format=debian --strict-relation-map -o - input.toml
+${HOLO_BUILD} --format=debian --relation-map=map2.toml --strict-relation-map -o - input.toml | ${DUMP_PACKAGE} | grep Depends
+${HOLO_BUILD} --format=pacman --relation-map=map2.toml --strict-relation-map -o - input.toml
+
+echo checking invalid relation map
+echo checking invalid relation map >&2
+${HOLO_BUILD} --format=debian --relation-map=broken.toml -o - input.toml
+
+rm -f input.toml map1.toml map2.toml broken.toml
@@ -0,0 +1,34 @@
+#!/bin/sh
+
+# check the `holo-build vercmp` subcommand, and the warning when a newer build
+# of the same package exists in the output directory
+
+echo checking vercmp
+echo checking vercmp >&2
+for FORMAT in debian pacman rpm; do
+    for VERSIONS in "1.0 1.0" "1.0 1.0.1" "1.0-2 1.0-10" "1:0.9 2.0" "1.0rc1 1.0"; do
+        echo "$FORMAT $VERSIONS: $(${HOLO_BUILD} vercmp --format=$FORMAT $VERSIONS)"
+    done
+done
+${HOLO_BUILD} vercmp --format=debian 1.0
+
+echo checking downgrade detection
+echo checking downgrade detection >&2
+mkdir -p out
+for VERSION in 1.1 1.0; do
+    cat > input.toml <<EOT
+[package]
+name = "package"
+version = "$VERSION"
+author = "Holo Build <holo.build@example.org>"
+EOT
+    ${HOLO_BUILD} --format=pacman -o out input.toml
+done
+# other packages with a similar name are not considered
+sed -i 's/"package"/"package-extra"/; s/"1.0"/"2.0"/' input.toml
+${HOLO_BUILD} --format=pacman -o out input.toml
+sed -i 's/"package-extra"/"package"/; s/"2.0"/"0.9"/' input.toml
+${HOLO_BUILD} --format=pacman -o out input.toml
+ls out
+
+rm -rf -- input.toml out
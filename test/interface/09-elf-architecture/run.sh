@@ -0,0 +1,37 @@
+#!/bin/sh
+
+# check that the package architecture is derived from ELF binaries when
+# architecture = "auto" is given, and that ELF binaries are rejected in
+# architecture-independent packages
+
+make_input() {
+    ARCH="$1"
+    shift
+    cat <<EOT
+[package]
+name = "package"
+version = "1.0"
+author = "Holo Build <holo.build@example.org>"
+architecture = "$ARCH"
+EOT
+    for BINARY in "$@"; do
+        cat <<EOT
+[[file]]
+path = "/usr/bin/$BINARY"
+contentFrom = "$BINARY"
+mode = "0755"
+EOT
+    done
+}
+
+echo checking architecture = auto
+echo checking architecture = auto >&2
+make_input auto helper-x86_64 | ${HOLO_BUILD} --format=debian --suggest-filename
+make_input auto helper-aarch64 | ${HOLO_BUILD} --format=rpm --suggest-filename
+make_input auto | ${HOLO_BUILD} --format=pacman --suggest-filename
+make_input auto helper-armhf | ${HOLO_BUILD} --format=pacman --suggest-filename
+make_input auto helper-x86_64 helper-aarch64 | ${HOLO_BUILD} --format=pacman --suggest-filename
+
+echo checking architecture = any
+echo checking architecture = any >&2
+make_input any helper-x86_64 | ${HOLO_BUILD} --format=pacman --suggest-filename
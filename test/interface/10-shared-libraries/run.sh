@@ -0,0 +1,54 @@
+#!/bin/sh
+
+# check that relations to shared libraries are generated from ELF binaries
+# when package.autoSharedLibraries is set
+
+cat > input.toml <<EOT
+[package]
+name = "package"
+version = "1.0"
+author = "Holo Build <holo.build@example.org>"
+architecture = "x86_64"
+requires = ["libc6"]
+autoSharedLibraries = true
+sharedLibraryMap = "shlibs.toml"
+
+[[file]]
+path = "/usr/bin/helper"
+contentFrom = "helper"
+mode = "0755"
+
+[[file]]
+path = "/usr/lib/libfoo.so.1"
+contentFrom = "libfoo.so.1"
+EOT
+
+cat > shlibs.toml <<EOT
+["libc.so.6"]
+debian = "libc6"
+pacman = "glibc"
+EOT
+
+echo checking generated relations for RPM
+echo checking generated relations for RPM >&2
+${HOLO_BUILD} --format=rpm -o - input.toml | ${DUMP_PACKAGE} | grep -a -A8 'tag 10\(47\|49\) '
+
+echo checking generated relations for Debian and pacman
+echo checking generated relations for Debian and pacman >&2
+${HOLO_BUILD} --format=debian -o - input.toml
+cat >> shlibs.toml <<EOT
+["libbar.so.2"]
+debian = "libbar2"
+pacman = "bar"
+EOT
+${HOLO_BUILD} --format=debian -o - input.toml | ${DUMP_PACKAGE} | grep -a Depends
+${HOLO_BUILD} --format=pacman -o - input.toml | ${DUMP_PACKAGE} | grep -a 'depend ='
+
+echo checking that generated relations are opt-in
+echo checking that generated relations are opt-in >&2
+sed -i '/^autoSharedLibraries/d' input.toml
+${HOLO_BUILD} --format=debian -o - input.toml
+sed -i '/^sharedLibraryMap/d' input.toml
+${HOLO_BUILD} --format=debian -o - input.toml | ${DUMP_PACKAGE} | grep -a Depends
+
+rm -f input.toml shlibs.toml
@@ -0,0 +1,65 @@
+#!/bin/sh
+
+# check that requirements on interpreters are generated from shebang lines
+# when package.autoInterpreters is set
+
+cat > input.toml <<EOT
+[package]
+name = "package"
+version = "1.0"
+author = "Holo Build <holo.build@example.org>"
+autoInterpreters = true
+interpreterMap = "interpreters.toml"
+
+[[file]]
+path = "/usr/bin/tool"
+content = "#!/usr/bin/env python3\nprint('hello')\n"
+mode = "0755"
+
+[[file]]
+path = "/usr/bin/other-tool"
+content = "#!/usr/bin/perl -w\nprint 'hello';\n"
+mode = "0755"
+
+[[file]]
+path = "/usr/share/package/example.sh"
+content = "#!/usr/bin/zsh\necho not executable\n"
+
+[[action]]
+on = "setup"
+script = "tool --setup"
+EOT
+
+cat > interpreters.toml <<EOT
+["/usr/bin/python3"]
+debian = "python3"
+pacman = "python"
+EOT
+
+echo checking generated requirements for RPM
+echo checking generated requirements for RPM >&2
+${HOLO_BUILD} --format=rpm -o - input.toml | ${DUMP_PACKAGE} | grep -a -A6 'tag 1049 '
+
+echo checking generated requirements for Debian and pacman
+echo checking generated requirements for Debian and pacman >&2
+${HOLO_BUILD} --format=debian -o - input.toml
+cat >> interpreters.toml <<EOT
+["/usr/bin/perl"]
+debian = "perl"
+pacman = "perl"
+["/bin/bash"]
+debian = "bash"
+["/bin/sh"]
+pacman = "bash"
+EOT
+${HOLO_BUILD} --format=debian -o - input.toml | ${DUMP_PACKAGE} | grep -a Depends
+${HOLO_BUILD} --format=pacman -o - input.toml | ${DUMP_PACKAGE} | grep -a 'depend ='
+
+echo checking that generated requirements are opt-in
+echo checking that generated requirements are opt-in >&2
+sed -i '/^autoInterpreters/d' input.toml
+${HOLO_BUILD} --format=debian -o - input.toml
+sed -i '/^interpreterMap/d' input.toml
+${HOLO_BUILD} --format=debian -o - input.toml | ${DUMP_PACKAGE} | grep -a Depends
+
+rm -f input.toml interpreters.toml
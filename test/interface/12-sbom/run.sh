@@ -0,0 +1,58 @@
+#!/bin/sh
+
+# check the generation of SPDX software bills of materials with --sbom
+
+cat > input.toml <<EOT
+[package]
+name = "package"
+version = "1.0"
+author = "Holo Build <holo.build@example.org>"
+description = "example package"
+license = "MIT"
+requires = ["foo >= 1.0", "bar | baz"]
+conflicts = ["qux"]
+
+[[file]]
+path = "/etc/package.conf"
+content = "foo"
+
+[[symlink]]
+path = "/etc/package-link.conf"
+target = "package.conf"
+EOT
+
+# the version of holo-build is not known to the test
+normalize_version() {
+    sed 's/"Tool: holo-build-[^"]*"/"Tool: holo-build-VERSION"/'
+}
+
+echo checking sidecar SBOM
+echo checking sidecar SBOM >&2
+mkdir -p out
+${HOLO_BUILD} --format=rpm --sbom=sidecar -o out input.toml
+ls out
+normalize_version < out/package-1.0-1.noarch.rpm.spdx.json
+# the SBOM is reproducible, so it is not considered to be overwritten
+${HOLO_BUILD} --format=rpm --sbom=sidecar -o out input.toml
+
+echo checking embedded SBOM
+echo checking embedded SBOM >&2
+${HOLO_BUILD} --format=debian --sbom=embed -o - input.toml | ${DUMP_PACKAGE} | grep -a 'is regular file\|"fileName"\|purl\|pkg:'
+
+echo checking that only the generator\'s metadata files are skipped
+echo checking that only the generator\'s metadata files are skipped >&2
+cat >> input.toml <<EOT
+
+[[file]]
+path = "/.hidden"
+content = "bar"
+EOT
+${HOLO_BUILD} --format=pacman --pacman-alternatives=first --sbom=sidecar -o out input.toml
+grep '"fileName"' out/package-1.0-1-any.pkg.tar.xz.spdx.json
+
+echo checking invalid usage
+echo checking invalid usage >&2
+${HOLO_BUILD} --format=rpm --sbom=sidecar -o - input.toml
+${HOLO_BUILD} --format=rpm --sbom=spdx input.toml
+
+rm -rf -- out input.toml
@@ -0,0 +1,65 @@
+#!/bin/sh
+
+# check the generation of provenance statements with --provenance
+
+cat > input.toml <<EOT
+[package]
+name = "package"
+version = "1.0"
+author = "Holo Build <holo.build@example.org>"
+relationMap = "relations.toml"
+
+[[file]]
+path = "/etc/package.conf"
+contentFrom = "signing-key.pub"
+EOT
+cat > relations.toml <<EOT
+[foo]
+pacman = "foo-pacman"
+EOT
+
+# the version of holo-build is not known to the test
+normalize_version() {
+    sed 's/"holo-build": "[^"]*"/"holo-build": "VERSION"/'
+}
+
+echo checking unsigned provenance statement
+echo checking unsigned provenance statement >&2
+mkdir -p out
+${HOLO_BUILD} --format=pacman --provenance -o out input.toml
+ls out
+normalize_version < out/package-1.0-1-any.pkg.tar.xz.intoto.json
+# the subject digest matches the package
+sha256sum out/package-1.0-1-any.pkg.tar.xz
+# the digest of the package definition is that of the file as read
+sha256sum input.toml
+# relation maps are recorded, too (including the one from --relation-map)
+sed 's/foo-pacman/bar-pacman/' < relations.toml > other-relations.toml
+rm -f out/*
+${HOLO_BUILD} --format=pacman --provenance --relation-map=other-relations.toml -o out input.toml
+grep -A2 '"name": "[a-z-]*relations.toml"' out/package-1.0-1-any.pkg.tar.xz.intoto.json
+sha256sum relations.toml other-relations.toml
+
+echo checking signed provenance statement
+echo checking signed provenance statement >&2
+rm -f out/*
+${HOLO_BUILD} --format=pacman --provenance --provenance-key=signing-key.pem -o out input.toml
+# the signature can be verified with the public key
+python3 - out/package-1.0-1-any.pkg.tar.xz.intoto.json > out/pae <<EOP
+import base64, json, sys
+envelope = json.load(open(sys.argv[1]))
+payload = base64.b64decode(envelope['payload'])
+pae = b'DSSEv1 %d %s %d ' % (len(envelope['payloadType']), envelope['payloadType'].encode(), len(payload)) + payload
+sys.stdout.buffer.write(pae)
+open(sys.argv[1] + '.sig', 'wb').write(base64.b64decode(envelope['signatures'][0]['sig']))
+print(envelope['payloadType'], file=sys.stderr)
+EOP
+openssl pkeyutl -verify -pubin -inkey signing-key.pub -rawin -in out/pae -sigfile out/package-1.0-1-any.pkg.tar.xz.intoto.json.sig
+
+echo checking invalid usage
+echo checking invalid usage >&2
+${HOLO_BUILD} --format=pacman --provenance -o - input.toml
+${HOLO_BUILD} --format=pacman --provenance-key=signing-key.pem input.toml
+${HOLO_BUILD} --format=pacman --provenance --provenance-key=input.toml input.toml
+
+rm -rf -- out input.toml relations.toml other-relations.toml
@@ -0,0 +1,34 @@
+#!/bin/sh
+
+# check that --compression overrides package.compression, and that invalid
+# values are rejected
+
+cat > input.toml <<EOT
+[package]
+name = "package"
+version = "1.0"
+author = "Holo Build <holo.build@example.org>"
+compression = "zstd"
+EOT
+
+echo checking compression from package definition
+echo checking compression from package definition >&2
+${HOLO_BUILD} --format=pacman --suggest-filename input.toml
+${HOLO_BUILD} --format=debian -o - input.toml | ${DUMP_PACKAGE} | grep -a 'tar\.'
+
+echo checking --compression
+echo checking --compression >&2
+${HOLO_BUILD} --format=pacman --compression=gzip --suggest-filename input.toml
+${HOLO_BUILD} --format=pacman --compression=none --suggest-filename input.toml
+${HOLO_BUILD} --format=debian --compression=none -o - input.toml | ${DUMP_PACKAGE} | grep -a 'tar '
+${HOLO_BUILD} --format=rpm --compression=xz -o - input.toml | ${DUMP_PACKAGE} | grep -a 'PayloadIs\|payload:'
+
+echo checking unsupported compression
+echo checking unsupported compression >&2
+${HOLO_BUILD} --format=rpm --compression=none -o - input.toml
+
+echo checking invalid compression
+echo checking invalid compression >&2
+${HOLO_BUILD} --format=pacman --compression=brotli -o - input.toml
+
+rm -f input.toml
@@ -0,0 +1,43 @@
+#!/bin/sh
+
+# check --compression-level and --jobs, and that the result does not depend on
+# the number of jobs
+
+# this is larger than one chunk of every compression format (4 MiB for gzip,
+# 3 MiB for xz at level 1, 32 MiB for zstd), so all of them are split
+seq 1 5000000 > large.txt
+
+cat > input.toml <<EOT
+[package]
+name = "package"
+version = "1.0"
+author = "Holo Build <holo.build@example.org>"
+
+[[file]]
+path = "/usr/share/package/large.txt"
+contentFrom = "large.txt"
+EOT
+
+echo checking --compression-level
+echo checking --compression-level >&2
+${HOLO_BUILD} --format=rpm --compression-level=fast -o - input.toml | ${DUMP_PACKAGE} | grep -a -A1 PAYLOADFLAGS
+${HOLO_BUILD} --format=rpm --compression=zstd --compression-level=19 -o - input.toml | ${DUMP_PACKAGE} | grep -a -A1 PAYLOADFLAGS
+
+echo checking reproducibility with --jobs
+echo checking reproducibility with --jobs >&2
+for compression in gzip xz zstd; do
+    ${HOLO_BUILD} --format=pacman --compression=$compression --compression-level=1 --jobs=1 -o out1 input.toml
+    ${HOLO_BUILD} --format=pacman --compression=$compression --compression-level=1 -j 3 -o out3 input.toml
+    cmp out1 out3 && echo "$compression: identical"
+    $compression -dc out1 | tar -xOf - usr/share/package/large.txt | cmp - large.txt && echo "$compression: decompresses correctly"
+    rm -f out1 out3
+done
+
+echo checking invalid compression levels
+echo checking invalid compression levels >&2
+${HOLO_BUILD} --format=pacman --compression-level=0 -o - input.toml
+${HOLO_BUILD} --format=pacman --compression-level=best -o - input.toml
+${HOLO_BUILD} --format=debian --compression-level=12 -o - input.toml
+${HOLO_BUILD} --format=pacman --jobs=-1 -o - input.toml
+
+rm -f input.toml large.txt
@@ -38,6 +38,7 @@ run_testcase() {
             fi
         fi
     done
+    return $EXIT_CODE
 }
 
 # this var will be set to 1 when a testcase fails
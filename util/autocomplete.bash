@@ -3,10 +3,18 @@ _holo_build() {
     local cur="${COMP_WORDS[COMP_CWORD]}"
     local prev="${COMP_WORDS[COMP_CWORD-1]}"
     if [[ $cur = -* ]]; then
-        COMPREPLY=( $(compgen -W "-f --force --format --help -o --output --suggest-filename -V --version" -- "$cur") )
+        COMPREPLY=( $(compgen -W "--compression --compression-level -f --force --format --help -j --jobs -o --output --package-groups --pacman-alternatives --provenance --provenance-key --relation-map --sbom --strict-relation-map --suggest-filename -V --version" -- "$cur") )
     elif [ "$COMP_CWORD" -gt 0 ]; then
-        if [[ $prev = --format ]]; then
+        if [[ $prev = --compression ]]; then
+            COMPREPLY=( $(compgen -W "none gzip lzma xz zstd" -- "$cur") )
+        elif [[ $prev = --compression-level ]]; then
+            COMPREPLY=( $(compgen -W "fast" -- "$cur") )
+        elif [[ $prev = --format ]]; then
             COMPREPLY=( $(compgen -W "debian pacman rpm" -- "$cur") )
+        elif [[ $prev = --pacman-alternatives ]]; then
+            COMPREPLY=( $(compgen -W "error first" -- "$cur") )
+        elif [[ $prev = --sbom ]]; then
+            COMPREPLY=( $(compgen -W "none sidecar embed" -- "$cur") )
         fi
     fi
 }